@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Clients with the binaryTransforms capability use this format on UDP instead of JSON.
+// Numbers are varints, floats are multiplied by BINARY_FLOAT_SCALE and sent as zigzag varints.
+//
+// transformUpdate: tag, playerId, roomId, token, sequence, position xyz, rotation xyz, velocity xyz
+// transformSnapshot: tag, snapshotId, baseSnapshotId, flags, part, partCount,
+// entry count, entries of playerId + position xyz + rotation xyz, removed count, removed playerIds
+//
+// Strings are sent as their length followed by the bytes. JSON datagrams always start with '{', so the tag tells them apart.
+// Snapshots are still acknowledged with JSON snapshotAck datagrams
+const BINARY_TRANSFORM_UPDATE = 1
+const BINARY_TRANSFORM_SNAPSHOT = 2
+const BINARY_FLOAT_SCALE = 100
+
+// Flags of a transformSnapshot
+const SNAPSHOT_KEYFRAME = 1
+const SNAPSHOT_DELTA = 2
+
+type binaryReader struct {
+	buf []byte
+	err error
+}
+
+func (r *binaryReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errors.New("datagram ended in the middle of a number")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return value
+}
+
+func (r *binaryReader) float() float64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = errors.New("datagram ended in the middle of a number")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return float64(value) / BINARY_FLOAT_SCALE
+}
+
+func (r *binaryReader) string() string {
+	length := r.uint()
+	if r.err != nil {
+		return ""
+	}
+	if length > uint64(len(r.buf)) {
+		r.err = errors.New("datagram ended in the middle of a string")
+		return ""
+	}
+	value := string(r.buf[:length])
+	r.buf = r.buf[length:]
+	return value
+}
+
+func decodeBinaryTransformUpdate(message_raw []byte) (map[string]interface{}, error) { //Turns the datagram into the same message a JSON transformUpdate would be
+	r := binaryReader{buf: message_raw[1:]}
+	message := map[string]interface{}{"type": "transformUpdate"}
+	message["playerId"] = strconv.FormatUint(r.uint(), 10)
+	message["roomId"] = r.string()
+	message["token"] = r.string()
+	message["sequence"] = float64(r.uint())
+	var t Transform
+	for i := range t.Position {
+		t.Position[i] = r.float()
+	}
+	for i := range t.Rotation {
+		t.Rotation[i] = r.float()
+	}
+	velocity := []interface{}{}
+	for i := 0; i < 3; i++ {
+		velocity = append(velocity, r.float())
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	message["newTransform"] = t.String()
+	message["velocity"] = velocity
+	return message, nil
+}
+
+func appendUint(buf []byte, value uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], value)
+	return append(buf, tmp[:n]...)
+}
+
+func appendFloat(buf []byte, value float64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], int64(math.Round(value*BINARY_FLOAT_SCALE)))
+	return append(buf, tmp[:n]...)
+}
+
+func appendBinaryTransform(buf []byte, playerId int, t Transform) []byte {
+	buf = appendUint(buf, uint64(playerId))
+	for _, v := range t.Position {
+		buf = appendFloat(buf, v)
+	}
+	for _, v := range t.Rotation {
+		buf = appendFloat(buf, v)
+	}
+	return buf
+}
+
+func sortedIds(transforms map[int]Transform) []int {
+	ids := []int{}
+	for id := range transforms {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
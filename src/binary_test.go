@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBinaryTransformUpdate(t *testing.T) {
+	buf := []byte{BINARY_TRANSFORM_UPDATE}
+	buf = appendUint(buf, 42)
+	buf = appendUint(buf, 5)
+	buf = append(buf, "ROOM1"...)
+	buf = appendUint(buf, 6)
+	buf = append(buf, "secret"...)
+	buf = appendUint(buf, 7)
+	for _, v := range []float64{1.5, -2.25, 300, 0, 90, -45.5, 10, 0, -10} {
+		buf = appendFloat(buf, v)
+	}
+	message, err := decodeBinaryTransformUpdate(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message["type"] != "transformUpdate" || message["playerId"] != "42" || message["roomId"] != "ROOM1" || message["token"] != "secret" || message["sequence"] != float64(7) {
+		t.Fatalf("unexpected header fields: %v", message)
+	}
+	transform, ok := parseTransform(message["newTransform"].(string))
+	if !ok {
+		t.Fatalf("newTransform can't be read: %v", message["newTransform"])
+	}
+	if transform.Position != [3]float64{1.5, -2.25, 300} || transform.Rotation != [3]float64{0, 90, -45.5} {
+		t.Fatalf("unexpected transform: %v", message["newTransform"])
+	}
+	velocity := message["velocity"].([]interface{})
+	if len(velocity) != 3 || velocity[0] != float64(10) || velocity[2] != float64(-10) {
+		t.Fatalf("unexpected velocity: %v", velocity)
+	}
+	//Every cut off datagram has to be rejected instead of read as zeros
+	for i := 1; i < len(buf); i++ {
+		if _, err := decodeBinaryTransformUpdate(buf[:i]); err == nil {
+			t.Fatalf("datagram cut off after %d bytes was accepted", i)
+		}
+	}
+}
+
+func TestEncodeBinaryPart(t *testing.T) {
+	s := transformSnapshot{
+		snapshotId:     12,
+		baseSnapshotId: 9,
+		isDelta:        true,
+		transforms: map[int]Transform{
+			3: {Position: [3]float64{1, 2, 3}, Rotation: [3]float64{0, 180, 0}},
+			8: {Position: [3]float64{-4.5, 0.01, 1000}, Rotation: [3]float64{10, 20, 30}},
+		},
+	}
+	raw := []byte(s.encodeBinaryPart([]int{3, 8}, 1, 2, []int{5}))
+	if raw[0] != BINARY_TRANSFORM_SNAPSHOT {
+		t.Fatalf("wrong tag %d", raw[0])
+	}
+	r := binaryReader{buf: raw[1:]}
+	if r.uint() != 12 || r.uint() != 9 {
+		t.Fatal("wrong snapshot ids")
+	}
+	flags := r.buf[0]
+	r.buf = r.buf[1:]
+	if flags != SNAPSHOT_DELTA {
+		t.Fatalf("wrong flags %d", flags)
+	}
+	if r.uint() != 1 || r.uint() != 2 {
+		t.Fatal("wrong part numbers")
+	}
+	count := int(r.uint())
+	if count != 2 {
+		t.Fatalf("expected 2 entries but got %d", count)
+	}
+	for i := 0; i < count; i++ {
+		id := int(r.uint())
+		expected, ok := s.transforms[id]
+		if !ok {
+			t.Fatalf("unexpected player %d", id)
+		}
+		var got Transform
+		for j := range got.Position {
+			got.Position[j] = r.float()
+		}
+		for j := range got.Rotation {
+			got.Rotation[j] = r.float()
+		}
+		if got.Position != expected.Position || got.Rotation != expected.Rotation {
+			t.Fatalf("player %d came back as %v instead of %v", id, got, expected)
+		}
+	}
+	if r.uint() != 1 || r.uint() != 5 {
+		t.Fatal("wrong removed players")
+	}
+	if r.err != nil || len(r.buf) != 0 {
+		t.Fatalf("datagram didn't end where expected: %v, %d bytes left", r.err, len(r.buf))
+	}
+}
+
+func TestEncodeSplitsBigSnapshots(t *testing.T) {
+	s := transformSnapshot{snapshotId: 100, baseSnapshotId: 99, isDelta: true, transforms: map[int]Transform{}, removed: []int{1, 2, 3}}
+	for id := 10; id < 400; id++ {
+		f := float64(id)
+		s.transforms[id] = Transform{Position: [3]float64{f * 1000.123, -f * 999.456, f}, Rotation: [3]float64{f, -f, math.Mod(f, 360)}}
+	}
+	for _, isBinary := range []bool{true, false} {
+		datagrams := s.encode(isBinary)
+		if len(datagrams) < 2 {
+			t.Fatalf("binary %v: expected the snapshot to be split but got %d datagrams", isBinary, len(datagrams))
+		}
+		for i, datagram := range datagrams {
+			if len(datagram) > maxDatagramSize {
+				t.Fatalf("binary %v: datagram %d has %d bytes, only %s are allowed", isBinary, i, len(datagram), strconv.Itoa(maxDatagramSize))
+			}
+		}
+	}
+}
+
+func TestBinarySnapshotsSkipUnreadableTransforms(t *testing.T) {
+	s := transformSnapshot{
+		snapshotId: 1,
+		isKeyframe: true,
+		isDelta:    true,
+		transforms: map[int]Transform{
+			3: {Position: [3]float64{1, 2, 3}},
+			4: {raw: "not a transform", onlyRaw: true},
+		},
+	}
+	datagrams := s.encode(true)
+	if len(datagrams) != 1 {
+		t.Fatalf("expected one datagram but got %d", len(datagrams))
+	}
+	r := binaryReader{buf: []byte(datagrams[0])[1:]}
+	r.uint()
+	r.uint()
+	r.buf = r.buf[1:]
+	r.uint()
+	r.uint()
+	if count := r.uint(); count != 1 || r.uint() != 3 {
+		t.Fatal("only player 3 should be in the binary snapshot")
+	}
+	//JSON clients still get the string the way it was sent
+	if datagrams := s.encode(false); len(datagrams) != 1 || !strings.Contains(datagrams[0], "not a transform") {
+		t.Fatalf("the raw transform is missing from %v", datagrams)
+	}
+}
+
+func TestParseTransform(t *testing.T) {
+	transform, ok := parseTransform("(1, 2, 3)|(10, 20, 30)|(4, 5, 6)")
+	if !ok || transform.Position != [3]float64{1, 2, 3} || transform.Rotation != [3]float64{10, 20, 30} || transform.Velocity != [3]float64{4, 5, 6} {
+		t.Fatalf("unexpected transform %+v", transform)
+	}
+	for _, broken := range []string{"", "(1, 2, 3)", "(1, 2)|(1, 2, 3)", "(1, 2, 3)|(0, 0, 0, 1)", "(1, NaN, 3)|(0, 0, 0)", "(a, b, c)|(0, 0, 0)"} {
+		if _, ok := parseTransform(broken); ok {
+			t.Fatalf("%q should not be readable", broken)
+		}
+	}
+}
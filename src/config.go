@@ -1,6 +1,100 @@
 package main
 
+import (
+	"os"
+	"time"
+)
+
 const PORT_UDP = 9535
 const PORT_TCP = 9536
 
+// The version of the protocol this server speaks, clients older than MIN_PROTOCOL_VERSION are turned away.
+// Version 2: the server works out damage and deaths itself, playerHit has to come from the shooter and say where it hit
+// Version 3: the server flies rockets and sends rocketHit and rocketExpired, playerHit only for rockets without a rocketId
+const PROTOCOL_VERSION = 3
+const MIN_PROTOCOL_VERSION = 3
+
 var namesFileLocation = "names.txt"
+
+// The health players start with if the game mode doesn't set a startHealth
+var defaultStartHealth = 100
+
+// The damage every bullet and rocket type does, the server works out the health of the players with it
+var weaponsFileLocation = "weapons.json"
+
+// How many transform snapshots per second are sent to the players of a room
+var transformTickRate = 20
+
+// Players using delta snapshots get a full snapshot this many ticks after the last one
+var snapshotKeyframeInterval = 20
+
+// How many unacknowledged snapshots are kept per player before the oldest are forgotten
+var snapshotHistorySize = 32
+
+// Transform snapshots bigger than this are split over several datagrams
+var maxDatagramSize = 1200
+
+// Longer transform strings are dropped, so a single entry can't blow up the snapshots of the room
+var maxTransformLength = 256
+
+// Planes further away from a player than this are only sent to it every farUpdateInterval ticks,
+// an interestRadius of 0 sends everything and a farUpdateInterval of 0 never sends far planes
+var interestRadius = 0.0
+var farUpdateInterval = 5
+
+// How far back the positions of the players are kept to check hits against where the target was when the shooter fired
+var rewindWindow = time.Second
+
+// How far behind the newest snapshot clients show the other planes
+var interpolationDelay = 100 * time.Millisecond
+
+// How many units a hit may be off from where the server thinks the target was
+var hitTolerance = 15.0
+
+// How many outgoing websocket messages can be queued for a client before it counts as falling behind
+var sendQueueSize = 64
+
+// What happens to a client whose send queue is full: "drop" skips the message, "disconnect" closes its connection
+var slowClientPolicy = "disconnect"
+
+// Optional protocol features the server supports, clients list theirs in the hello message
+var serverCapabilities = []string{"deltaSnapshots", "binaryTransforms"}
+
+// How long a new connection has to introduce itself with a hello message
+var handshakeTimeout = 5 * time.Second
+
+// A websocket that doesn't answer pings for this long counts as dead, pings are sent a bit more often than that
+var pongWait = 30 * time.Second
+var pingPeriod = pongWait * 9 / 10
+
+// How long writing a single message to a websocket may take
+var writeWait = 10 * time.Second
+
+// How long a player in a running game may stop sending transform updates before it counts as disconnected
+var udpSilenceTimeout = 15 * time.Second
+
+// The /stats endpoint shows private rooms and anti cheat counters, so it is only answered for requests
+// with this token in the X-Stats-Token header. It is turned off while the token is empty
+var statsToken = os.Getenv("STATS_TOKEN")
+
+// After this many wrong room passwords in a row a connection has to wait wrongPasswordLockout before it can try again
+var maxWrongPasswords = 5
+var wrongPasswordLockout = 30 * time.Second
+
+// Shots may come in this much faster than the cooldown of the weapon allows because of network jitter
+var fireRateTolerance = 0.8
+
+// How long a shot can still be reported as a hit, used for weapons without a lifetimeMs
+var shotLifetime = 3 * time.Second
+
+// How fast every plane type may fly in units per second, "default" is used for plane types that aren't listed
+var maxPlaneSpeeds = map[string]float64{"default": 300}
+
+// Moves may be this much faster than the plane allows before they count as a violation
+var speedTolerance = 1.25
+
+// What happens to a move that is too fast: "clamp" shortens it to the allowed distance, "reject" drops it
+var movementPolicy = "clamp"
+
+// How long a player who lost its connection keeps its place in the room
+var resumeGracePeriod = 30 * time.Second
@@ -1,12 +1,12 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -14,43 +14,108 @@ import (
 )
 
 type Player struct {
-	transform     string
+	transform     Transform
+	hasTransform  bool
 	name          string
 	currentTeam   string
 	playerId      string
+	sessionToken  string
 	planeTypes    string
 	currentHealth int
 	kills         int
 	isNew         bool
 	isDead        bool
 	isReady       bool
-	websocket     *websocket.Conn
-	udpConn       net.PacketConn
-	udpAddr       net.Addr
-}
-
-type RoomBase struct {
-	sceneIndex     string
-	roomRules      map[string]string
-	availableTeams []string
-	players        map[int]Player
-	isOpen         bool
+	weaponStates  map[string]*weaponState
+	//Shots that were faster than the weapon allows
+	suspiciousShots int
+	capabilities    map[string]bool
+	websocket       *websocket.Conn
+	outbound        chan []byte
+	udpConn         net.PacketConn
+	udpAddr         net.Addr
+	lastUDPTime     time.Time
+	//False until the position of the transform can be used to check how fast the player moves
+	hasPosition        bool
+	movementViolations int
+	//The newest transform sequence number, droppedPackets counts duplicate and stale datagrams, reorderedPackets only the stale ones
+	lastSequence     uint32
+	hasSequence      bool
+	droppedPackets   int
+	reorderedPackets int
+	snapshots        *snapshotState
+	lockedTarget     int
+	hasLockedTarget  bool
+	positionHistory  []Transform
+	rejectedHits     int
+	//Only set on the Player bound to the connection
+	latency *latencyTracker
+	//Only used on the Player bound to the connection
+	wrongPasswords      int
+	passwordLockedUntil time.Time
 }
 
 var allPlayerIds []int
-var rooms = map[string]*RoomBase{}
 var playersWithoutRoom = map[int]Player{}
 
-var mutex = &sync.Mutex{}
-
-func handleNewPlayer(conn *websocket.Conn) { //This is called as soon as the player connects to the websocket
+// The Id of the room every player who isn't in the lobby is in
+var playerRooms = map[int]string{}
+
+// Only guards allPlayerIds, playersWithoutRoom and playerRooms, rooms guard their own state
+var lobbyMutex = &sync.Mutex{}
+
+func handleNewPlayer(conn *websocket.Conn, outbound chan []byte, hello *HelloRequest) *Player { //This is called as soon as the player has introduced itself on the websocket
+	//Only the features both sides know about can be used
+	capabilities := map[string]bool{}
+	agreedCapabilities := []string{}
+	for _, capability := range hello.Capabilities {
+		for _, serverCapability := range serverCapabilities {
+			if capability == serverCapability && !capabilities[capability] {
+				capabilities[capability] = true
+				agreedCapabilities = append(agreedCapabilities, capability)
+			}
+		}
+	}
+	//Putting the player back into its room if it only lost the connection for a moment
+	if len(hello.ResumeToken) > 0 {
+		if returningPlayer, ok := resumePlayer(conn, outbound, hello, capabilities, agreedCapabilities); ok {
+			return returningPlayer
+		}
+		fmt.Println("Could not resume a session, treating the client as a new player")
+	}
 	newId := getNewPlayerId()
-	conn.WriteMessage(1, []byte("{\"type\":\"setId\", \"newId\":\""+strconv.Itoa(newId)+"\"}"))
+	//The token has to be sent along with every UDP datagram so nobody else can take over the players UDP session
+	sessionToken := getRandomToken()
+	newPlayer := Player{websocket: conn, outbound: outbound, isNew: true, playerId: strconv.Itoa(newId), sessionToken: sessionToken, capabilities: capabilities}
+	hm := HelloMessage{
+		NewId:           newId,
+		Token:           sessionToken,
+		ResumeToken:     newResumeToken(newId),
+		ProtocolVersion: PROTOCOL_VERSION,
+		Capabilities:    agreedCapabilities,
+	}
+	sendTCP(&newPlayer, encodeMessage(hm))
 	fmt.Println("Client connected and has now Id: " + strconv.Itoa(newId))
-	playersWithoutRoom[newId] = Player{websocket: conn, isNew: true, playerId: strconv.Itoa(newId)}
+	putPlayerIntoLobby(newId, newPlayer)
+	//The returned Player is bound to the connection and tells who sent the messages arriving on it
+	return &newPlayer
+}
+
+func isClaimedIdentity(sender *Player, claimedId flexInt) bool { //Checks that the id a message claims to come from belongs to the connection it arrived on
+	if claimedId.String() != sender.playerId {
+		fmt.Println("Player " + sender.playerId + " tried to send a message as player " + claimedId.String())
+		em := ErrorMessage{
+			ErrorText: "You can not send messages on behalf of another player",
+		}
+		sendTCP(sender, encodeMessage(em))
+		return false
+	}
+	return true
 }
 
 func getNewPlayerId() int { //Returns an unique Id for a new player
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
 	var newId int
 	if len(allPlayerIds) > 0 {
 		newId = allPlayerIds[len(allPlayerIds)-1] + 1
@@ -61,10 +126,100 @@ func getNewPlayerId() int { //Returns an unique Id for a new player
 	return allPlayerIds[len(allPlayerIds)-1]
 }
 
+func putPlayerIntoLobby(playerId int, p Player) {
+	lobbyMutex.Lock()
+	playersWithoutRoom[playerId] = p
+	delete(playerRooms, playerId)
+	lobbyMutex.Unlock()
+}
+
+func setPlayerRoom(playerId int, roomId string) {
+	lobbyMutex.Lock()
+	playerRooms[playerId] = roomId
+	lobbyMutex.Unlock()
+}
+
+func getPlayerRoom(playerId int) (string, bool) {
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	roomId, ok := playerRooms[playerId]
+	return roomId, ok
+}
+
+func takePlayerFromLobby(playerId int) (Player, bool) { //Removes the player from the lobby so it can be moved into a room
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	p, ok := playersWithoutRoom[playerId]
+	delete(playersWithoutRoom, playerId)
+	return p, ok
+}
+
+func removePlayerFromLobby(playerId int) {
+	lobbyMutex.Lock()
+	delete(playersWithoutRoom, playerId)
+	delete(playerRooms, playerId)
+	lobbyMutex.Unlock()
+}
+
+func handleLostConnection(p *Player) { //Called when the websocket of a player is gone, no matter if the client said goodbye or not
+	playerId, _ := strconv.Atoi(p.playerId)
+	if roomId, ok := getPlayerRoom(playerId); ok {
+		isInRoom := false
+		hasNewConnection := false
+		isWaiting := false
+		withRoom(roomId, func(room *RoomBase) {
+			isInRoom, hasNewConnection = room.suspendPlayer(playerId, p.websocket)
+			//Starting the grace period inside the room so a resume can't slip in before it
+			if isInRoom && !hasNewConnection {
+				isWaiting = waitForResume(playerId, roomId)
+			}
+		})
+		//The player already came back on another connection
+		if hasNewConnection {
+			return
+		}
+		if isInRoom {
+			fmt.Println("Lost the connection to player " + p.playerId + " in room " + roomId)
+			if isWaiting {
+				return
+			}
+			disconnectClient(roomId, playerId)
+		}
+	}
+	dropResumeSession(playerId)
+	removePlayerFromLobby(playerId)
+}
+
+func prepareForRoom(p Player, name string, team string, startHealth int, planeTypes string) Player { //Resets the player so it can enter a room
+	if p.isNew {
+		p.hasTransform = false
+		p.isNew = false
+		p.kills = 0
+	}
+	p.name = name
+	p.currentTeam = team
+	p.currentHealth = startHealth
+	p.weaponStates = nil
+	p.hasSequence = false
+	//Nothing from the last room may leak into the new one
+	p.snapshots = nil
+	p.lockedTarget, p.hasLockedTarget = 0, false
+	p.positionHistory = nil
+	p.hasPosition = false
+	p.planeTypes = planeTypes
+	return p
+}
+
 func decodeClientMessageOnUDP(udpConnection net.PacketConn, addr net.Addr, message_raw []byte) { //This is called when a message is recived on the udp connection
 	var message map[string]interface{}
-	if json.Unmarshal(message_raw, &message) != nil {
-		fmt.Println("Error decoding Message on UDP: " + string(message_raw))
+	var err error
+	if len(message_raw) > 0 && message_raw[0] == BINARY_TRANSFORM_UPDATE {
+		message, err = decodeBinaryTransformUpdate(message_raw)
+	} else {
+		err = json.Unmarshal(message_raw, &message)
+	}
+	if err != nil {
+		fmt.Println("Error decoding Message on UDP: " + err.Error())
 	} else {
 		if messageType, ok := message["type"]; ok {
 			messageType = fmt.Sprintf("%v", messageType)
@@ -73,412 +228,647 @@ func decodeClientMessageOnUDP(udpConnection net.PacketConn, addr net.Addr, messa
 				playerId, _ := strconv.Atoi(fmt.Sprintf("%v", message["playerId"]))
 				roomId := fmt.Sprintf("%v", message["roomId"])
 				//fmt.Println("Trying to update transform of player " + strconv.Itoa(pId) + " the new Transform is: " + fmt.Sprintf("%v", message["newTransform"]))
-				mutex.Lock()
-				//Setting the connection data if it is a new Connection
-
-				if _, roomExists := rooms[roomId]; roomExists {
-					if _, playerExists := rooms[roomId].players[playerId]; playerExists {
-						if rooms[roomId].players[playerId].udpConn == nil {
-							movingPlayer := rooms[roomId].players[playerId]
-							movingPlayer.udpConn = udpConnection
-							movingPlayer.udpAddr = addr
-							rooms[roomId].players[playerId] = movingPlayer
+				room, roomExists := getRoom(roomId)
+				if !roomExists {
+					return
+				}
+				room.do(func(room *RoomBase) {
+					movingPlayer, playerExists := room.players[playerId]
+					//Dropping the datagram if it doesn't belong to the session of the player
+					if !playerExists || !isValidUDPSession(movingPlayer, message, addr) {
+						return
+					}
+					//Setting the connection data if it is a new Connection
+					if movingPlayer.udpConn == nil {
+						movingPlayer.udpConn = udpConnection
+						movingPlayer.udpAddr = addr
+					}
+					//Udpating the transform
+					now := time.Now()
+					movingPlayer.lastUDPTime = now
+					fresh := movingPlayer.isFreshDatagram(message)
+					room.players[playerId] = movingPlayer
+					if !fresh {
+						return
+					}
+					//Every other client gets the transform 20 times a second, so it has to fit into a snapshot
+					rawTransform := fmt.Sprintf("%v", message["newTransform"])
+					if len(rawTransform) > maxTransformLength {
+						fmt.Println("Player", movingPlayer.playerId, "sent a transform that is", len(rawTransform), "bytes long, only", maxTransformLength, "are allowed")
+						return
+					}
+					transform, ok := readTransform(message, &movingPlayer, now)
+					if !ok {
+						if !room.isOpen {
+							fmt.Println("Player " + movingPlayer.playerId + " sent a transform that can't be read: " + rawTransform)
+							return
 						}
-						//Udpating the transform
-						modifiedPlayer := rooms[roomId].players[playerId]
-						modifiedPlayer.transform = fmt.Sprintf("%v", message["newTransform"])
-						rooms[roomId].players[playerId] = modifiedPlayer
-						mutex.Unlock()
-						//Informing the other clients
-						updateClientTransforms(roomId)
-					} else {
-						mutex.Unlock()
+						//Nobody is flying yet, so the transform is passed on the way it is
+						transform = Transform{raw: rawTransform, onlyRaw: true, Timestamp: now}
+						transform.Sequence, _ = readSequence(message)
+					}
+					if transform, ok = room.checkMovement(&movingPlayer, transform, now); ok {
+						movingPlayer.transform = transform
+						movingPlayer.hasTransform = true
+						movingPlayer.rememberTransform(transform)
 					}
-				} else {
-					mutex.Unlock()
+					room.players[playerId] = movingPlayer
+					//The other clients are informed with the next tick of the room
+				})
+			case "snapshotAck":
+				playerId, _ := strconv.Atoi(fmt.Sprintf("%v", message["playerId"]))
+				roomId := fmt.Sprintf("%v", message["roomId"])
+				snapshotId, ok := readUint32(message, "snapshotId")
+				room, roomExists := getRoom(roomId)
+				if !ok || !roomExists {
+					return
 				}
+				room.do(func(room *RoomBase) {
+					if p, playerExists := room.players[playerId]; playerExists && isValidUDPSession(p, message, addr) {
+						room.ackSnapshot(playerId, snapshotId)
+					}
+				})
 			}
 		}
 	}
 }
 
-func decodeClientMessageOnTCP(message_raw []byte) {
-	var message map[string]interface{}
-	if json.Unmarshal(message_raw, &message) != nil {
+func isValidUDPSession(p Player, message map[string]interface{}, addr net.Addr) bool { //Checks the token of a datagram and that it comes from the address the session is bound to
+	token := fmt.Sprintf("%v", message["token"])
+	if len(p.sessionToken) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(p.sessionToken)) != 1 {
+		fmt.Println("Dropped UDP packet with an invalid token for player " + p.playerId + " from " + addr.String())
+		return false
+	}
+	if p.udpAddr != nil && p.udpAddr.String() != addr.String() {
+		fmt.Println("Dropped UDP packet for player " + p.playerId + " from " + addr.String() + ", the session is bound to " + p.udpAddr.String())
+		return false
+	}
+	return true
+}
+
+var tcpHandlers = map[string]tcpHandler{
+	"createRoom":         handle(handleCreateRoom),
+	"joinRoom":           handle(handleJoinRoom),
+	"listRooms":          handle(handleListRooms),
+	"ready":              handle(handleReady),
+	"unready":            handle(handleUnready),
+	"changeTeam":         handle(handleChangeTeam),
+	"startGame":          handle(handleStartGame),
+	"changeSettings":     handle(handleChangeSettings),
+	"rejoin":             handle(handleRejoin),
+	"targetLocked":       handle(handleTargetLocked),
+	"shootBulletRequest": handle(handleShootBullet),
+	"shootRocketRequest": handle(handleShootRocket),
+	"playerHit":          handle(handlePlayerHit),
+	"playerDied":         handle(handlePlayerDied),
+	"clientDisconnected": handle(handleClientDisconnected),
+	"transferOwnership":  handle(handleTransferOwnership),
+	"kickPlayer":         handle(handleKickPlayer),
+	"banPlayer":          handle(handleBanPlayer),
+	"completeDelete":     handle(handleCompleteDelete),
+}
+
+func decodeClientMessageOnTCP(sender *Player, message_raw []byte) {
+	var envelope MessageEnvelope
+	if json.Unmarshal(message_raw, &envelope) != nil {
 		fmt.Println("Error decoding Message on TCP: " + string(message_raw))
-	} else {
-		mesageType := fmt.Sprintf("%v", message["type"])
-		switch mesageType {
-		case "createRoom":
-			playerId, _ := strconv.Atoi(fmt.Sprintf("%v", message["playerId"]))
-			newRoomId := getRandomRoomId()
-			playerName := fmt.Sprintf("%v", message["name"])
-			planeTypesByte, _ := json.Marshal(message["planeTypes"])
-			planeTypes := string(planeTypesByte)
-			startHealth, _ := strconv.Atoi(fmt.Sprintf("%v", message["startHealth"]))
-			selectedWorld := fmt.Sprintf("%v", message["worldIndex"])
-			gameModeInfo := convertMap(message["gameModeInfo"].(map[string]interface{}))
-			var teams []string
-			if hasTeams, _ := strconv.ParseBool(gameModeInfo["hasTeams"]); hasTeams {
-				teams = strings.Split(strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(gameModeInfo["teamColors"], "[", ""), "]", ""), "\"", ""), " ")
-			} else {
-				teams = append(teams, "All Players")
-			}
-			if len(newRoomId) == 0 {
-				affectedPlayer := playersWithoutRoom[playerId]
-				em := ErrorMessage{
-					ErrorText: "A room with that room Id was already created",
-				}
-				sendTCP(&affectedPlayer, em.getMessageJSON())
-				return
-			}
-			if len(playerName) == 0 {
-				rand.Seed(time.Now().UnixNano())
-				playerName = names[rand.Intn(len(names)-1)]
-			}
-			newPlayer := Player{}
-			if playersWithoutRoom[playerId].isNew {
-				newPlayer = Player{playerId: playersWithoutRoom[playerId].playerId, name: playerName, currentTeam: teams[rand.Intn(len(teams))], websocket: playersWithoutRoom[playerId].websocket, transform: "0", currentHealth: startHealth, planeTypes: planeTypes, isNew: false, kills: 0}
-			} else {
-				newPlayer = playersWithoutRoom[playerId]
-				newPlayer.currentHealth = startHealth
-				newPlayer.planeTypes = planeTypes
-				newPlayer.name = playerName
-				newPlayer.currentTeam = teams[rand.Intn(len(teams))]
-			}
-			playerInfo := map[int]Player{playerId: newPlayer}
-			newRoom := RoomBase{players: playerInfo, sceneIndex: selectedWorld, availableTeams: teams, roomRules: gameModeInfo, isOpen: true}
-			mutex.Lock()
-			rooms[newRoomId] = &newRoom
-			delete(playersWithoutRoom, playerId)
-			mutex.Unlock()
-			currentPlayer := rooms[newRoomId].players[playerId]
-			ccm := ClientConnectedMessage{
-				Id:           playerId,
-				Name:         playerName,
-				Team:         newRoom.players[playerId].currentTeam,
-				IsReady:      newRoom.players[playerId].isReady,
-				PlaneTypes:   planeTypes,
-				PlayerHealth: newPlayer.currentHealth,
-			}
-			sendTCP(&currentPlayer, ccm.getMessageJSON())
-			crm := CreatedRoomMessage{
-				newRoomId:   newRoomId,
-				startHealth: newPlayer.currentHealth,
-				sceneIndex:  selectedWorld,
-			}
-			sendTCP(&currentPlayer, crm.getMessageJSON())
-		case "joinRoom":
-			playerId, _ := strconv.Atoi(fmt.Sprintf("%v", message["playerId"]))
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			playerName := fmt.Sprintf("%v", message["name"])
-			planeTypesByte, _ := json.Marshal(message["planeTypes"])
-			planeTypes := string(planeTypesByte)
-			startHealth, _ := strconv.Atoi(fmt.Sprintf("%v", message["startHealth"]))
-			//Checking if the room exists
-			if _, ok := rooms[roomId]; !ok {
-				affectedPlayer := playersWithoutRoom[playerId]
-
-				em := ErrorMessage{
-					ErrorText: "No room with such Id exists",
-				}
-				sendTCP(&affectedPlayer, em.getMessageJSON())
-				return
-			}
-			//Checking if the room is Open
-			if !rooms[roomId].isOpen {
-				affectedPlayer := playersWithoutRoom[playerId]
-				em := ErrorMessage{
-					ErrorText: "the game in this room has already started",
-				}
-				sendTCP(&affectedPlayer, em.getMessageJSON())
+		em := ErrorMessage{
+			ErrorText: "The message could not be decoded",
+		}
+		sendTCP(sender, encodeMessage(em))
+		return
+	}
+	handler, ok := tcpHandlers[envelope.Type]
+	if !ok {
+		fmt.Println("Unknown message type on TCP: " + envelope.Type)
+		em := ErrorMessage{
+			ErrorText: "Unknown message type " + envelope.Type,
+		}
+		sendTCP(sender, encodeMessage(em))
+		return
+	}
+	if err := handler(sender, message_raw); err != nil {
+		fmt.Println("Error decoding " + envelope.Type + " message from player " + sender.playerId + ": " + err.Error())
+		em := ErrorMessage{
+			ErrorText: "Invalid " + envelope.Type + " message: " + err.Error(),
+		}
+		sendTCP(sender, encodeMessage(em))
+	}
+}
+
+func handleCreateRoom(sender *Player, request *CreateRoomRequest, message_raw []byte) {
+	if !isClaimedIdentity(sender, request.PlayerId) {
+		return
+	}
+	playerId := int(request.PlayerId)
+	playerName := string(request.Name)
+	planeTypes := getPlaneTypes(request.PlaneTypes)
+	selectedWorld := string(request.WorldIndex)
+	gameModeInfo := convertMap(request.GameModeInfo)
+	teams := getTeams(gameModeInfo)
+	startHealth := getStartHealth(gameModeInfo)
+	if len(playerName) == 0 {
+		rand.Seed(time.Now().UnixNano())
+		playerName = names[rand.Intn(len(names)-1)]
+	}
+	waitingPlayer, ok := takePlayerFromLobby(playerId)
+	if !ok {
+		em := ErrorMessage{
+			ErrorText: "You are already in a room",
+		}
+		sendTCP(sender, encodeMessage(em))
+		return
+	}
+	newPlayer := prepareForRoom(waitingPlayer, playerName, teams[rand.Intn(len(teams))], startHealth, planeTypes)
+	newRoom := newRoom(selectedWorld, gameModeInfo, teams)
+	newRoom.players[playerId] = newPlayer
+	newRoom.ownerId = playerId
+	newRoom.isPublic = bool(request.IsPublic)
+	newRoom.setPassword(string(request.Password))
+	newRoomId := registerRoom(newRoom)
+	setPlayerRoom(playerId, newRoomId)
+	ccm := ClientConnectedMessage{
+		Id:           playerId,
+		Name:         playerName,
+		Team:         newPlayer.currentTeam,
+		IsReady:      newPlayer.isReady,
+		PlaneTypes:   json.RawMessage(planeTypes),
+		PlayerHealth: newPlayer.currentHealth,
+	}
+	sendTCP(&newPlayer, encodeMessage(ccm))
+	crm := CreatedRoomMessage{
+		NewRoomId:   newRoomId,
+		StartHealth: newPlayer.currentHealth,
+		SceneIndex:  selectedWorld,
+	}
+	sendTCP(&newPlayer, encodeMessage(crm))
+}
+
+func handleJoinRoom(sender *Player, request *JoinRoomRequest, message_raw []byte) {
+	if !isClaimedIdentity(sender, request.PlayerId) {
+		return
+	}
+	playerId := int(request.PlayerId)
+	roomId := string(request.RoomId)
+	playerName := string(request.Name)
+	planeTypes := getPlaneTypes(request.PlaneTypes)
+	if time.Now().Before(sender.passwordLockedUntil) {
+		em := ErrorMessage{
+			ErrorText: "Too many wrong passwords, try again later",
+		}
+		sendTCP(sender, encodeMessage(em))
+		return
+	}
+	//Checking if the room exists
+	room, ok := getRoom(roomId)
+	if !ok {
+		em := ErrorMessage{
+			ErrorText: "No room with such Id exists",
+		}
+		sendTCP(sender, encodeMessage(em))
+		return
+	}
+	if len(playerName) == 0 {
+		rand.Seed(time.Now().UnixNano())
+		playerName = names[rand.Intn(len(names)-1)]
+	}
+	waitingPlayer, ok := takePlayerFromLobby(playerId)
+	if !ok {
+		em := ErrorMessage{
+			ErrorText: "You are already in a room",
+		}
+		sendTCP(sender, encodeMessage(em))
+		return
+	}
+
+	errorText := "No room with such Id exists"
+	joined := false
+	wrongPassword := false
+	room.do(func(room *RoomBase) {
+		if room.bannedIds[playerId] {
+			errorText = "You are banned from this room"
+			return
+		}
+		if !room.checkPassword(string(request.Password)) {
+			errorText = "Wrong password"
+			wrongPassword = true
+			return
+		}
+		//Checking if the room is Open
+		if !room.isOpen {
+			errorText = "the game in this room has already started"
+			return
+		}
+		//Checking if the room is already full
+		if hasPlayerLimit, _ := strconv.ParseBool(room.roomRules["hasMaxPlayers"]); hasPlayerLimit {
+			if maxPlayerAmount, _ := strconv.Atoi(room.roomRules["maxPlayerCount"]); len(room.players) >= maxPlayerAmount {
+				errorText = "The room is full"
 				return
 			}
+		}
 
-			//Checking if the room is already full
-			if hasPlayerLimit, _ := strconv.ParseBool(rooms[roomId].roomRules["hasMaxPlayers"]); hasPlayerLimit {
-				if maxPlayerAmount, _ := strconv.Atoi(rooms[roomId].roomRules["maxPlayerCount"]); len(rooms[roomId].players) >= maxPlayerAmount {
-					affectedPlayer := playersWithoutRoom[playerId]
-					em := ErrorMessage{
-						ErrorText: "The room is full",
-					}
-					sendTCP(&affectedPlayer, em.getMessageJSON())
-					return
+		//Moving the new Player Object into the room
+		newPlayer := prepareForRoom(waitingPlayer, playerName, room.availableTeams[rand.Intn(len(room.availableTeams))], getStartHealth(room.roomRules), planeTypes)
+		room.players[playerId] = newPlayer
+		setPlayerRoom(playerId, roomId)
+		joined = true
+
+		//Informing the client itself and the clients who already were in the room of the join event
+		ccm := ClientConnectedMessage{
+			Id:           playerId,
+			Name:         playerName,
+			Team:         newPlayer.currentTeam,
+			IsReady:      newPlayer.isReady,
+			PlaneTypes:   json.RawMessage(planeTypes),
+			PlayerHealth: newPlayer.currentHealth,
+		}
+		room.broadcastTCP(encodeMessage(ccm))
+
+		jsm := JoinSuccessMessage{
+			NewRoomId:    roomId,
+			StartHealth:  newPlayer.currentHealth,
+			SceneIndex:   room.sceneIndex,
+			GameMode:     room.roomRules["gameModeType"],
+			OwnerId:      room.ownerId,
+			OtherClients: room.getOtherClientData(),
+		}
+		sendTCP(&newPlayer, encodeMessage(jsm))
+	})
+	if wrongPassword {
+		sender.wrongPasswords++
+		if sender.wrongPasswords >= maxWrongPasswords {
+			fmt.Println("Player " + sender.playerId + " entered too many wrong passwords")
+			sender.wrongPasswords = 0
+			sender.passwordLockedUntil = time.Now().Add(wrongPasswordLockout)
+		}
+	} else if joined {
+		sender.wrongPasswords = 0
+	}
+	if !joined {
+		//Putting the player back if it couldn't join
+		putPlayerIntoLobby(playerId, waitingPlayer)
+		em := ErrorMessage{
+			ErrorText: errorText,
+		}
+		sendTCP(sender, encodeMessage(em))
+	}
+}
+
+func handleListRooms(sender *Player, request *ListRoomsRequest, message_raw []byte) {
+	sendTCP(sender, encodeMessage(RoomListMessage{Rooms: getPublicRooms()}))
+}
+
+func handleReady(sender *Player, request *ReadyRequest, message_raw []byte) {
+	setReady(sender, request, message_raw, true)
+}
+
+func handleUnready(sender *Player, request *ReadyRequest, message_raw []byte) {
+	setReady(sender, request, message_raw, false)
+}
+
+func setReady(sender *Player, request *ReadyRequest, message_raw []byte, isReady bool) {
+	if !isClaimedIdentity(sender, request.Id) {
+		return
+	}
+	playerId := int(request.Id)
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		if affectedPlayer, ok := room.players[playerId]; ok {
+			affectedPlayer.isReady = isReady
+			room.players[playerId] = affectedPlayer
+			room.broadcastTCP(string(message_raw))
+		}
+	})
+}
+
+func handleChangeTeam(sender *Player, request *ChangeTeamRequest, message_raw []byte) {
+	if !isClaimedIdentity(sender, request.Id) {
+		return
+	}
+	playerId := int(request.Id)
+	team := string(request.Team)
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		p, ok := room.players[playerId]
+		if !ok {
+			return
+		}
+		errorText := ""
+		if !room.isOpen {
+			errorText = "Teams can only be changed before the game starts"
+		} else {
+			errorText = "There is no such team in this room"
+			for _, availableTeam := range room.availableTeams {
+				if availableTeam == team {
+					errorText = ""
 				}
 			}
-			if len(playerName) == 0 {
-				rand.Seed(time.Now().UnixNano())
-				playerName = names[rand.Intn(len(names)-1)]
+		}
+		if len(errorText) > 0 {
+			em := ErrorMessage{
+				ErrorText: errorText,
 			}
+			sendTCP(sender, encodeMessage(em))
+			return
+		}
+		//The team counts for team victories, so the server has to know it too
+		p.currentTeam = team
+		room.players[playerId] = p
+		room.broadcastTCP(string(message_raw))
+	})
+}
 
-			//Setting up a new Player Object
-			newPlayer := Player{}
-			if playersWithoutRoom[playerId].isNew {
-				newPlayer = Player{playerId: playersWithoutRoom[playerId].playerId, name: playerName, currentTeam: rooms[roomId].availableTeams[rand.Intn(len(rooms[roomId].availableTeams))], websocket: playersWithoutRoom[playerId].websocket, transform: "0", currentHealth: startHealth, planeTypes: planeTypes, isNew: false, kills: 0}
-			} else {
-				newPlayer = playersWithoutRoom[playerId]
-				newPlayer.currentHealth = startHealth
-				newPlayer.planeTypes = planeTypes
-				newPlayer.name = playerName
-				newPlayer.currentTeam = rooms[roomId].availableTeams[rand.Intn(len(rooms[roomId].availableTeams))]
-			}
-			//Moving the new Player Object into the room
-			mutex.Lock()
-			rooms[roomId].players[playerId] = newPlayer
-			//Deleting the playerId out of the playersWithoutRoom
-			delete(playersWithoutRoom, playerId)
-			mutex.Unlock()
-			//Informing the client itself and the clients who already were in the room of the join event
-			currentPlayer := rooms[roomId].players[playerId]
-
-			ccm := ClientConnectedMessage{
-				Id:           playerId,
-				Name:         playerName,
-				Team:         rooms[roomId].players[playerId].currentTeam,
-				IsReady:      rooms[roomId].players[playerId].isReady,
-				PlaneTypes:   planeTypes,
-				PlayerHealth: newPlayer.currentHealth,
-			}
-			broadcastTCP(roomId, ccm.getMessageJSON())
-
-			jsm := JoinSuccessMessage{
-				newRoomId:    roomId,
-				startHealth:  newPlayer.currentHealth,
-				sceneIndex:   rooms[roomId].sceneIndex,
-				gameMode:     rooms[roomId].roomRules["gameModeType"],
-				otherClients: getOtherClientData(roomId),
-			}
+func handleTargetLocked(sender *Player, request *TargetLockedRequest, message_raw []byte) {
+	playerId, _ := strconv.Atoi(sender.playerId)
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		p, ok := room.players[playerId]
+		if !ok {
+			return
+		}
+		//Players always get the transform of the plane they have locked on, no matter how far away it is
+		p.lockedTarget, p.hasLockedTarget = 0, false
+		if target, err := strconv.Atoi(string(request.Target)); err == nil && target != playerId {
+			p.lockedTarget, p.hasLockedTarget = target, true
+		}
+		room.players[playerId] = p
+		room.broadcastTCP(string(message_raw))
+	})
+}
 
-			sendTCP(&currentPlayer, jsm.getMessageJSON())
-
-		case "ready":
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			playerId, _ := strconv.Atoi(fmt.Sprintf("%v", message["Id"]))
-			mutex.Lock()
-			affectedPlayer := rooms[roomId].players[playerId]
-			affectedPlayer.isReady = true
-			rooms[roomId].players[playerId] = affectedPlayer
-			mutex.Unlock()
-			broadcastTCP(roomId, string(message_raw))
-		case "unready":
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			playerId, _ := strconv.Atoi(fmt.Sprintf("%v", message["Id"]))
-			mutex.Lock()
-			affectedPlayer := rooms[roomId].players[playerId]
-			affectedPlayer.isReady = false
-			rooms[roomId].players[playerId] = affectedPlayer
-			mutex.Unlock()
-			broadcastTCP(roomId, string(message_raw))
-		case "changeTeam":
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			broadcastTCP(roomId, string(message_raw))
-		case "startGame":
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			rooms[roomId].isOpen = false
-			fmt.Println("Room", roomId, "wants to start the game")
-			broadcastTCP(roomId, string(message_raw))
-		case "rejoin":
-			playerId, _ := strconv.Atoi(fmt.Sprintf("%v", message["playerId"]))
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			newHealth, _ := strconv.Atoi(fmt.Sprintf("%v", message["newHealth"]))
-			mutex.Lock()
-			rejoiningPlayer := rooms[roomId].players[playerId]
-			rejoiningPlayer.currentHealth = newHealth
-			rejoiningPlayer.isDead = false
-			rooms[roomId].players[playerId] = rejoiningPlayer
-			mutex.Unlock()
-
-			rjm := RejoinMessage{
-				playerId:  playerId,
-				newHealth: newHealth,
-			}
-			broadcastTCP(roomId, rjm.getMessageJSON())
-
-		case "targetLocked":
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			broadcastTCP(roomId, string(message_raw))
-		case "shootBulletRequest":
-			//Getting generall information about the bullet
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			bulletType := fmt.Sprintf("%v", message["bulletType"])
-			shooter := fmt.Sprintf("%v", message["shooter"])
-			gunIndex := fmt.Sprintf("%v", message["gunIndex"])
-
-			//Getting the starting velocity of the bullet
-			velocity := (message["velocity"]).([]interface{})
-			velocityVal, _ := json.Marshal(velocity)
-
-			//Getting direction the bullet has to fly towards
-			planeFacingDirection := (message["planeFacingDirection"]).([]interface{})
-			planeFacingDirectionVal, _ := json.Marshal(planeFacingDirection)
-
-			//Updating the clients in the room
-			bsm := BulletShotMessage{
-				bulletType:           bulletType,
-				shooter:              shooter,
-				gunIndex:             gunIndex,
-				velocity:             string(velocityVal),
-				planeFacingDirection: string(planeFacingDirectionVal),
-			}
-			broadcastTCP(roomId, bsm.getMessageJSON())
-
-		case "shootRocketRequest":
-			//Getting the Id of the room the bullet was shoot in
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			rocketType := fmt.Sprintf("%v", message["rocketType"])
-			shooter := fmt.Sprintf("%v", message["shooter"])
-			target := fmt.Sprintf("%v", message["target"])
-			gunIndex := fmt.Sprintf("%v", message["gunIndex"])
-
-			//Getting the starting velocity of the rocket
-			velocity := (message["velocity"]).([]interface{})
-			velocityVal, _ := json.Marshal(velocity)
-
-			//Getting direction the rocket has to fly towards
-			planeFacingDirection := (message["planeFacingDirection"]).([]interface{})
-			planeFacingDirectionVal, _ := json.Marshal(planeFacingDirection)
-
-			//fmt.Println("Server End  : " + "[\"" + xEnd + "\", \"" + yEnd + "\", \"" + zEnd + "\"]")
-			//Updating the clients in the room
-
-			rsm := RocketShotMessage{
-				rocketType:  rocketType,
-				shooter:     shooter,
-				gunIndex:    gunIndex,
-				velocity:    string(velocityVal),
-				facingAngle: string(planeFacingDirectionVal),
-				targetId:    target,
-			}
-			broadcastTCP(roomId, rsm.getMessageJSON())
-
-		case "playerHit":
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			playerId, _ := strconv.Atoi(fmt.Sprintf("%v", message["playerId"]))
-			//shooterId := fmt.Sprintf("%v", message["shooterId"])
-			damage, _ := strconv.Atoi(fmt.Sprintf("%v", message["damage"]))
-
-			mutex.Lock()
-			shotPlayer := rooms[roomId].players[playerId]
-			shotPlayer.currentHealth -= damage
-			rooms[roomId].players[playerId] = shotPlayer
-			mutex.Unlock()
-			broadcastTCP(roomId, "{\"type\":\"playerHit\", \"hitPlayerId\":\""+strconv.Itoa(playerId)+"\", \"newHealth\":\""+strconv.Itoa(shotPlayer.currentHealth)+"\",}")
-
-		case "playerDied":
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			playerId, _ := strconv.Atoi(fmt.Sprintf("%v", message["playerId"]))
-			shooterId, _ := strconv.Atoi(fmt.Sprintf("%v", message["shooterId"]))
-			isSuicide, _ := strconv.ParseBool(fmt.Sprintf("%v", message["isSuicide"]))
-			//Updating the kills of the shooter
-			if killer, ok := rooms[roomId].players[shooterId]; ok {
-				if killer.websocket != nil {
-					mutex.Lock()
-					if !isSuicide {
-						killer.kills += 1
-					}
-					rooms[roomId].players[shooterId] = killer
-					//Checking if the room has the rule to win with kills
-					if useKills, _ := strconv.ParseBool(rooms[roomId].roomRules["useKills"]); useKills {
-						fmt.Println("The killer ", shooterId, " has now ", killer.kills, " kills and he needs: ", rooms[roomId].roomRules["killsToWin"], " kills")
-						//If it does, checking if the killer has reached the kill Limit
-						if killsToWin, _ := strconv.Atoi(rooms[roomId].roomRules["killsToWin"]); killer.kills >= killsToWin {
-							//If he reached the limit, informing all the clients about the win/loss
-							mutex.Unlock()
-							fmt.Println("Someone has won the game")
-							broadcastTCP(roomId, "{\"type\":\"GameOver\", \"winnerType\":\"Single\",\"winner\":\""+strconv.Itoa(shooterId)+"\", \"lastKill\":\""+strconv.Itoa(playerId)+"\"}")
-							return
-						}
-					}
-					mutex.Unlock()
-				}
+func handleStartGame(sender *Player, request *RoomRequest, message_raw []byte) {
+	roomId := string(request.RoomId)
+	withRoom(roomId, func(room *RoomBase) {
+		if !room.isOwner(sender) {
+			return
+		}
+		room.isOpen = false
+		//Everybody is put onto its spawn point when the game starts
+		for playerId, p := range room.players {
+			p.hasPosition = false
+			p.positionHistory = nil
+			//Players get the whole udpSilenceTimeout to start flying
+			p.lastUDPTime = time.Now()
+			room.players[playerId] = p
+		}
+		fmt.Println("Room", roomId, "wants to start the game")
+		room.broadcastTCP(string(message_raw))
+	})
+}
+
+func handleChangeSettings(sender *Player, request *ChangeSettingsRequest, message_raw []byte) {
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		if !room.isOwner(sender) {
+			return
+		}
+		if !room.isOpen {
+			em := ErrorMessage{
+				ErrorText: "The settings can't be changed after the game has started",
 			}
-			if deadPlayer, ok := rooms[roomId].players[playerId]; ok {
-				if deadPlayer.websocket != nil {
-					mutex.Lock()
-					deadPlayer.isDead = true
-					rooms[roomId].players[playerId] = deadPlayer
-					mutex.Unlock()
-					sendTCP(&deadPlayer, "{\"type\":\"playerDied\", \"deadPlayer\":\""+strconv.Itoa(playerId)+"\", \"killer\":\""+strconv.Itoa(shooterId)+"\"}")
-					broadcastTCP(roomId, "{\"type\":\"playerDied\", \"deadPlayer\":\""+strconv.Itoa(playerId)+"\", \"killer\":\""+strconv.Itoa(shooterId)+"\"}")
-				}
+			sendTCP(sender, encodeMessage(em))
+			return
+		}
+		room.roomRules = convertMap(request.GameModeInfo)
+		room.availableTeams = getTeams(room.roomRules)
+		for playerId, p := range room.players {
+			//The game mode might start everybody with a different health now
+			p.currentHealth = getStartHealth(room.roomRules)
+			//Moving players out of teams that don't exist anymore
+			isAvailable := false
+			for _, team := range room.availableTeams {
+				isAvailable = isAvailable || team == p.currentTeam
 			}
-		case "clientDisconnected":
-			wasOwner, _ := strconv.ParseBool(fmt.Sprintf("%v", message["wasOwner"]))
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			disconnectedPlayerId, _ := strconv.Atoi(fmt.Sprintf("%v", message["Id"]))
-			disconnectClient(roomId, disconnectedPlayerId)
-			if _, ok := rooms[roomId]; ok && wasOwner {
-				newOwner := ""
-				for _, v := range rooms[roomId].players {
-					if v.websocket != nil {
-						newOwner = v.playerId
-						break
-					}
-				}
-				broadcastTCP(roomId, "{\"type\":\"transferOwnership\", \"newOwner\":\""+newOwner+"\"}")
+			if !isAvailable {
+				p.currentTeam = room.availableTeams[rand.Intn(len(room.availableTeams))]
 			}
-		case "transferOwnership":
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			broadcastTCP(roomId, string(message_raw))
-		case "completeDelete":
-			fmt.Println("A client quit the game")
-			pId, _ := strconv.Atoi(fmt.Sprintf("%v", message["playerId"]))
-			roomId := fmt.Sprintf("%v", message["roomId"])
-			if len(roomId) > 0 {
-				disconnectClient(roomId, pId)
+			room.players[playerId] = p
+		}
+		rsm := RoomSettingsMessage{
+			GameModeInfo: room.roomRules,
+			Clients:      room.getOtherClientData(),
+		}
+		room.broadcastTCP(encodeMessage(rsm))
+	})
+}
+
+func handleRejoin(sender *Player, request *RejoinRequest, message_raw []byte) {
+	if !isClaimedIdentity(sender, request.PlayerId) {
+		return
+	}
+	playerId := int(request.PlayerId)
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		rejoiningPlayer, ok := room.players[playerId]
+		if !ok {
+			return
+		}
+		//Only dead players can respawn, otherwise rejoin would heal and reload for free
+		if !rejoiningPlayer.isDead {
+			em := ErrorMessage{
+				ErrorText: "Only dead players can rejoin",
 			}
-			delete(playersWithoutRoom, pId)
+			sendTCP(sender, encodeMessage(em))
+			return
+		}
+		//Players always come back with the health they started with
+		rejoiningPlayer.currentHealth = getStartHealth(room.roomRules)
+		rejoiningPlayer.isDead = false
+		rejoiningPlayer.weaponStates = nil
+		rejoiningPlayer.hasPosition = false
+		rejoiningPlayer.positionHistory = nil
+		//Dead players don't send transforms, so the silence before the respawn doesn't count
+		rejoiningPlayer.lastUDPTime = time.Now()
+		room.players[playerId] = rejoiningPlayer
+
+		rjm := RejoinMessage{
+			PlayerId:  playerId,
+			NewHealth: rejoiningPlayer.currentHealth,
 		}
+		room.broadcastTCP(encodeMessage(rjm))
+	})
+}
+
+func handleShootBullet(sender *Player, request *ShootBulletRequest, message_raw []byte) {
+	if !isClaimedIdentity(sender, request.Shooter) {
+		return
 	}
+	stats, ok := getBulletStats(string(request.BulletType))
+	if !ok {
+		fmt.Println("Player", sender.playerId, "shot an unknown bullet type:", request.BulletType)
+		return
+	}
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		if !room.allowShot(int(request.Shooter), "bullet:"+string(request.BulletType), stats) {
+			return
+		}
+		//Updating the clients in the room
+		bsm := BulletShotMessage{
+			BulletType:           string(request.BulletType),
+			Shooter:              request.Shooter.String(),
+			GunIndex:             string(request.GunIndex),
+			Velocity:             toFloats(request.Velocity),
+			PlaneFacingDirection: toFloats(request.PlaneFacingDirection),
+		}
+		room.broadcastTCP(encodeMessage(bsm))
+	})
 }
 
-func disconnectClient(roomId string, playerId int) {
-	broadcastTCP(roomId, "{\"type\":\"clientDisconnected\", \"Id\":\""+strconv.Itoa(playerId)+"\"}")
-	mutex.Lock()
-	playersWithoutRoom[playerId] = rooms[roomId].players[playerId]
-	delete(rooms[roomId].players, playerId)
-	mutex.Unlock()
-	//Deleting the room if nobody is in it anymore
-	if len(rooms[roomId].players) == 0 {
-		mutex.Lock()
-		delete(rooms, roomId)
-		mutex.Unlock()
+func handleShootRocket(sender *Player, request *ShootRocketRequest, message_raw []byte) {
+	if !isClaimedIdentity(sender, request.Shooter) {
+		return
+	}
+	stats, ok := getRocketStats(string(request.RocketType))
+	if !ok {
+		fmt.Println("Player", sender.playerId, "shot an unknown rocket type:", request.RocketType)
 		return
 	}
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		shooterId := int(request.Shooter)
+		if !room.allowShot(shooterId, "rocket:"+string(request.RocketType), stats) {
+			return
+		}
+		direction := toFloats(request.PlaneFacingDirection)
+		if len(direction) != 3 {
+			direction = toFloats(request.Velocity)
+		}
+		rocketId, launched := room.launchRocket(room.players[shooterId], shooterId, string(request.RocketType), stats, direction, string(request.Target))
+		if !launched {
+			room.addUnflownRocket(shooterId, string(request.RocketType), stats)
+		}
+		//Updating the clients in the room
+		rsm := RocketShotMessage{
+			RocketId:    rocketId,
+			RocketType:  string(request.RocketType),
+			Shooter:     request.Shooter.String(),
+			GunIndex:    string(request.GunIndex),
+			Velocity:    toFloats(request.Velocity),
+			FacingAngle: toFloats(request.PlaneFacingDirection),
+			TargetId:    string(request.Target),
+		}
+		room.broadcastTCP(encodeMessage(rsm))
+	})
 }
 
-func updateClientTransforms(roomId string) {
-	mutex.Lock()
-	transforms := make(map[int]string)
-	playersCopy := &rooms[roomId].players
-	for k, v := range *playersCopy {
-		if len(v.transform) > 1 && v.websocket != nil && !v.isDead {
-			transforms[k] = v.transform
+func handlePlayerHit(sender *Player, request *PlayerHitRequest, message_raw []byte) {
+	if !isClaimedIdentity(sender, request.ShooterId) {
+		return
+	}
+	playerId := int(request.PlayerId)
+	shooterId := int(request.ShooterId)
+	var weapon WeaponStats
+	var weaponKey string
+	var ok bool
+	if len(request.RocketType) > 0 {
+		weapon, ok = getRocketStats(string(request.RocketType))
+	} else {
+		weapon, ok = getBulletStats(string(request.BulletType))
+		weaponKey = "bullet:" + string(request.BulletType)
+	}
+	if !ok {
+		fmt.Println("Player", shooterId, "hit someone with an unknown weapon:", request.BulletType, request.RocketType)
+		return
+	}
+	//The server decides itself what the rockets it flies hit
+	if request.RocketId != 0 {
+		return
+	}
+	rtt := sender.latency.getRTT()
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		shooter, ok := room.players[shooterId]
+		if !ok || shooter.isDead || playerId == shooterId {
+			return
 		}
+		shotPlayer, ok := room.players[playerId]
+		if !ok || shotPlayer.isDead {
+			return
+		}
+		//Every shot can only hit once, rockets only if the server didn't fly them
+		if len(request.RocketType) > 0 {
+			if !room.useUnflownRocket(shooterId, string(request.RocketType)) {
+				return
+			}
+		} else if !room.useShot(shooterId, weaponKey, weapon) {
+			return
+		}
+		shooter = room.players[shooterId]
+		//Checking the hit against where both planes were when the shooter fired
+		shotTime := room.getShotTime(int(request.SnapshotId), rtt)
+		if !room.isPossibleHit(shooter, shotPlayer, shotTime, weapon, toFloats(request.HitPosition)) {
+			shooter.rejectedHits++
+			room.players[shooterId] = shooter
+			return
+		}
+		room.damagePlayer(playerId, shooterId, weapon.Damage)
+	})
+}
+
+func handlePlayerDied(sender *Player, request *PlayerDiedRequest, message_raw []byte) { //Clients can only report that they killed themselves, every other death is decided by the server
+	if !isClaimedIdentity(sender, request.PlayerId) {
+		return
+	}
+	if !request.IsSuicide {
+		fmt.Println("Player " + sender.playerId + " claimed to have been killed, ignoring it")
+		return
 	}
-	mutex.Unlock()
-	jsonString, e := json.Marshal(transforms)
-	if e != nil {
-		fmt.Println("Something went wrong with getting the transforms")
+	playerId := int(request.PlayerId)
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		if deadPlayer, ok := room.players[playerId]; ok && !deadPlayer.isDead {
+			room.registerKill(playerId, playerId)
+		}
+	})
+}
+
+func handleClientDisconnected(sender *Player, request *ClientDisconnectedRequest, message_raw []byte) {
+	if !isClaimedIdentity(sender, request.Id) {
 		return
 	}
-	broadcastUDP(roomId, "{\"type\":\"updatePlayerTransform\",\"allPlayerTransformDict\":"+string(jsonString)+"}")
+	disconnectedPlayerId := int(request.Id)
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		room.disconnectPlayer(disconnectedPlayerId)
+	})
 }
 
-func getOtherClientData(roomId string) string {
-	type clientStruct struct {
-		Id           string
-		Name         string
-		Team         string
-		PlaneTypes   []string
-		PlayerHealth int
-		IsReady      bool
+func handleTransferOwnership(sender *Player, request *TransferOwnershipRequest, message_raw []byte) {
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		if !room.isOwner(sender) {
+			return
+		}
+		newOwner := int(request.NewOwner)
+		if _, ok := room.players[newOwner]; !ok {
+			em := ErrorMessage{
+				ErrorText: "The new owner has to be in the room",
+			}
+			sendTCP(sender, encodeMessage(em))
+			return
+		}
+		room.ownerId = newOwner
+		room.broadcastTCP(encodeMessage(TransferOwnershipMessage{NewOwner: strconv.Itoa(newOwner)}))
+	})
+}
+
+func handleKickPlayer(sender *Player, request *KickPlayerRequest, message_raw []byte) {
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		room.kickPlayer(sender, int(request.TargetId), string(request.Reason), false)
+	})
+}
+
+func handleBanPlayer(sender *Player, request *KickPlayerRequest, message_raw []byte) {
+	withRoom(string(request.RoomId), func(room *RoomBase) {
+		room.kickPlayer(sender, int(request.TargetId), string(request.Reason), true)
+	})
+}
+
+func handleCompleteDelete(sender *Player, request *CompleteDeleteRequest, message_raw []byte) {
+	if !isClaimedIdentity(sender, request.PlayerId) {
+		return
 	}
-	allClientData := []clientStruct{}
-	for _, client := range rooms[roomId].players {
-		planeTypes := strings.Split(strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(client.planeTypes, "\"", ""), "[", ""), "]", ""), ",")
-		allClientData = append(allClientData, clientStruct{Id: client.playerId, Name: client.name, Team: client.currentTeam, PlaneTypes: planeTypes, PlayerHealth: client.currentHealth, IsReady: client.isReady})
+	fmt.Println("A client quit the game")
+	pId := int(request.PlayerId)
+	roomId := string(request.RoomId)
+	if len(roomId) > 0 {
+		disconnectClient(roomId, pId)
 	}
-	result, _ := json.Marshal(allClientData)
-	return string(result)
+	removePlayerFromLobby(pId)
+}
+
+func disconnectClient(roomId string, playerId int) {
+	withRoom(roomId, func(room *RoomBase) {
+		room.disconnectPlayer(playerId)
+	})
 }
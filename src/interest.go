@@ -0,0 +1,23 @@
+package main
+
+import "strconv"
+
+func (room *RoomBase) getVisibleTransforms(recipient Player, transforms map[int]Transform) (map[int]Transform, map[int]bool) { //Leaves out planes that are far away from the recipient on most ticks
+	if interestRadius <= 0 || !recipient.hasTransform {
+		return transforms, nil
+	}
+	//Far planes are still sent every farUpdateInterval ticks, in between the clients keep their last transform
+	isFarTick := farUpdateInterval > 0 && room.snapshotId%uint32(farUpdateInterval) == 0
+	visible := map[int]Transform{}
+	held := map[int]bool{}
+	recipientId := recipient.playerId
+	for id, transform := range transforms {
+		isLocked := recipient.hasLockedTarget && recipient.lockedTarget == id
+		if isFarTick || isLocked || strconv.Itoa(id) == recipientId || transform.distanceTo(recipient.transform) <= interestRadius {
+			visible[id] = transform
+		} else if farUpdateInterval > 0 {
+			held[id] = true
+		}
+	}
+	return visible, held
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Measures the round trip time of a websocket with its pings, the write pump and the reader both use it
+type latencyTracker struct {
+	pingSentAt int64
+	rtt        int64
+}
+
+func (l *latencyTracker) pingSent() {
+	atomic.StoreInt64(&l.pingSentAt, time.Now().UnixNano())
+}
+
+func (l *latencyTracker) pongReceived() {
+	if sentAt := atomic.LoadInt64(&l.pingSentAt); sentAt > 0 {
+		atomic.StoreInt64(&l.rtt, time.Now().UnixNano()-sentAt)
+	}
+}
+
+func (l *latencyTracker) getRTT() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&l.rtt))
+}
+
+func (p *Player) rememberTransform(t Transform) { //Keeps the transforms of the last rewindWindow so hits can be checked against the past
+	p.positionHistory = append(p.positionHistory, t)
+	for len(p.positionHistory) > 0 && t.Timestamp.Sub(p.positionHistory[0].Timestamp) > rewindWindow {
+		p.positionHistory = p.positionHistory[1:]
+	}
+}
+
+func (p Player) positionAt(when time.Time) (Transform, bool) { //Where the player was at that moment, moves in between two updates are interpolated
+	history := p.positionHistory
+	if len(history) == 0 {
+		return Transform{}, false
+	}
+	if !when.After(history[0].Timestamp) {
+		return history[0], true
+	}
+	for i := 1; i < len(history); i++ {
+		if when.After(history[i].Timestamp) {
+			continue
+		}
+		before, after := history[i-1], history[i]
+		progress := float64(when.Sub(before.Timestamp)) / float64(after.Timestamp.Sub(before.Timestamp))
+		for j := range before.Position {
+			before.Position[j] += (after.Position[j] - before.Position[j]) * progress
+		}
+		before.Timestamp = when
+		return before, true
+	}
+	return history[len(history)-1], true
+}
+
+func (room *RoomBase) getShotTime(snapshotId int, rtt time.Duration) time.Time { //When the shooter saw the world the way it was when it fired
+	if sentAt, ok := room.snapshotTimes[uint32(snapshotId)]; ok && snapshotId > 0 {
+		return sentAt
+	}
+	return time.Now().Add(-rtt/2 - interpolationDelay)
+}
+
+func (room *RoomBase) isPossibleHit(shooter Player, target Player, when time.Time, weapon WeaponStats, hitPosition []float64) bool {
+	//Without the hit position most weapons have nothing that could be checked
+	if len(hitPosition) != 3 {
+		fmt.Println("Player", shooter.playerId, "hit player", target.playerId, "without saying where")
+		return false
+	}
+	targetThen, ok := target.positionAt(when)
+	if !ok {
+		//Nothing to check against if the target never sent its position
+		return true
+	}
+	if shooterThen, ok := shooter.positionAt(when); ok && weapon.Range > 0 {
+		if distance := shooterThen.distanceTo(targetThen); distance > weapon.Range+hitTolerance {
+			fmt.Println("Player", shooter.playerId, "hit player", target.playerId, "from", distance, "units away but the weapon only reaches", weapon.Range)
+			return false
+		}
+	}
+	var hitPoint Transform
+	copy(hitPoint.Position[:], hitPosition)
+	if distance := hitPoint.distanceTo(targetThen); distance > hitTolerance {
+		fmt.Println("Player", shooter.playerId, "hit player", target.playerId, distance, "units away from where it was")
+		return false
+	}
+	return true
+}
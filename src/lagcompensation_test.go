@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func playerWithHistory(playerId string, start time.Time, positions ...[3]float64) Player {
+	p := Player{playerId: playerId}
+	for i, position := range positions {
+		p.rememberTransform(Transform{Position: position, Timestamp: start.Add(time.Duration(i*100) * time.Millisecond)})
+	}
+	return p
+}
+
+func TestPositionAt(t *testing.T) {
+	start := time.Now()
+	p := playerWithHistory("1", start, [3]float64{0, 0, 0}, [3]float64{10, 0, 0}, [3]float64{10, 20, 0})
+	checks := []struct {
+		at       time.Duration
+		expected [3]float64
+	}{
+		{-time.Second, [3]float64{0, 0, 0}},          //Before the history starts
+		{0, [3]float64{0, 0, 0}},                     //Exactly on an update
+		{50 * time.Millisecond, [3]float64{5, 0, 0}}, //Halfway between two updates
+		{150 * time.Millisecond, [3]float64{10, 10, 0}},
+		{time.Second, [3]float64{10, 20, 0}}, //After the newest update
+	}
+	for _, check := range checks {
+		position, ok := p.positionAt(start.Add(check.at))
+		if !ok || position.distanceTo(Transform{Position: check.expected}) > 1e-9 {
+			t.Fatalf("at %v: expected %v but got %v", check.at, check.expected, position.Position)
+		}
+	}
+	if _, ok := (Player{}).positionAt(start); ok {
+		t.Fatal("a player without a history can't have a position")
+	}
+}
+
+func TestRememberTransformForgetsOldPositions(t *testing.T) {
+	start := time.Now()
+	var p Player
+	p.rememberTransform(Transform{Timestamp: start})
+	p.rememberTransform(Transform{Timestamp: start.Add(rewindWindow / 2)})
+	p.rememberTransform(Transform{Timestamp: start.Add(rewindWindow * 2)})
+	if len(p.positionHistory) != 1 {
+		t.Fatalf("expected only the newest position to be kept but there are %d", len(p.positionHistory))
+	}
+}
+
+func TestIsPossibleHit(t *testing.T) {
+	start := time.Now()
+	room := newRoom("1", map[string]string{}, []string{"red"})
+	shooter := playerWithHistory("1", start, [3]float64{0, 0, 0}, [3]float64{0, 0, 0})
+	//The target flew from 100 to 300 units away
+	target := playerWithHistory("2", start, [3]float64{100, 0, 0}, [3]float64{300, 0, 0})
+	when := start.Add(50 * time.Millisecond)
+	gun := WeaponStats{Range: 250}
+	checks := []struct {
+		name        string
+		weapon      WeaponStats
+		when        time.Time
+		hitPosition []float64
+		possible    bool
+	}{
+		{"hit where the target was", gun, when, []float64{200, 0, 0}, true},
+		{"hit within the tolerance", gun, when, []float64{200 + hitTolerance - 1, 0, 0}, true},
+		{"hit where the target is now", gun, when, []float64{300, 0, 0}, false},
+		{"no hit position", gun, when, nil, false},
+		{"broken hit position", gun, when, []float64{200, 0}, false},
+		{"out of range", gun, start.Add(100 * time.Millisecond), []float64{300, 0, 0}, false},
+		{"weapon without a range", WeaponStats{}, start.Add(100 * time.Millisecond), []float64{300, 0, 0}, true},
+	}
+	for _, check := range checks {
+		if possible := room.isPossibleHit(shooter, target, check.when, check.weapon, check.hitPosition); possible != check.possible {
+			t.Fatalf("%s: expected %v but got %v", check.name, check.possible, possible)
+		}
+	}
+}
@@ -4,6 +4,7 @@ var names []string
 
 func main() {
 	names = readFile(namesFileLocation)
+	weapons = readWeapons(weaponsFileLocation)
 	go startTCP()
 	startUDP()
 }
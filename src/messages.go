@@ -1,87 +1,237 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"strconv"
 )
 
+// Every message the server sends has to say which type it is, encodeMessage puts that into the "type" field
+type outboundMessage interface {
+	messageType() string
+}
+
+// The answer to the hello of a client, it also hands out the Id of the player
+type HelloMessage struct {
+	NewId           int      `json:"newId,string"`
+	Token           string   `json:"token"`
+	ResumeToken     string   `json:"resumeToken"`
+	Resumed         bool     `json:"resumed"`
+	ProtocolVersion int      `json:"protocolVersion"`
+	Capabilities    []string `json:"capabilities"`
+}
+
 type ErrorMessage struct {
-	ErrorText string
+	ErrorText string `json:"value"`
 }
 
 type ClientConnectedMessage struct {
-	Id           int
+	Id           int             `json:"Id,string"`
+	Name         string          `json:"Name"`
+	Team         string          `json:"Team"`
+	IsReady      bool            `json:"IsReady,string"`
+	PlaneTypes   json.RawMessage `json:"PlaneTypes"`
+	PlayerHealth int             `json:"PlayerHealth,string"`
+}
+
+type ClientDisconnectedMessage struct {
+	Id int `json:"Id,string"`
+}
+
+// Sent to the room when a player lost its connection but may still come back
+type ClientConnectionLostMessage struct {
+	Id int `json:"Id,string"`
+}
+
+type ClientReconnectedMessage struct {
+	Id int `json:"Id,string"`
+}
+
+// Everything a player needs to continue where it was after resuming its session
+type ResumeStateMessage struct {
+	RoomId       string       `json:"roomId"`
+	SceneIndex   string       `json:"sceneIndex"`
+	GameMode     string       `json:"gameMode"`
+	GameStarted  bool         `json:"gameStarted"`
+	OwnerId      int          `json:"ownerId,string"`
+	Team         string       `json:"team"`
+	Health       int          `json:"health,string"`
+	Kills        int          `json:"kills,string"`
+	IsDead       bool         `json:"isDead"`
+	IsReady      bool         `json:"isReady"`
+	OtherClients []ClientData `json:"otherClients"`
+}
+
+type CreatedRoomMessage struct {
+	NewRoomId   string `json:"newRoomId"`
+	StartHealth int    `json:"startHealth,string"`
+	SceneIndex  string `json:"sceneIndex"`
+}
+
+// One entry of the otherClients list in JoinSuccessMessage
+type ClientData struct {
+	Id           string
 	Name         string
 	Team         string
-	IsReady      bool
-	PlaneTypes   string
+	PlaneTypes   []string
 	PlayerHealth int
+	IsReady      bool
 }
 
-type CreatedRoomMessage struct {
-	newRoomId   string
-	startHealth int
-	sceneIndex  string
+type JoinSuccessMessage struct {
+	NewRoomId    string       `json:"newRoomId"`
+	StartHealth  int          `json:"startHealth,string"`
+	SceneIndex   string       `json:"sceneIndex"`
+	GameMode     string       `json:"gameMode"`
+	OwnerId      int          `json:"ownerId,string"`
+	OtherClients []ClientData `json:"otherClients"`
 }
 
-type JoinSuccessMessage struct {
-	newRoomId    string
-	startHealth  int
-	sceneIndex   string
-	gameMode     string
-	otherClients string
+// Sent to the whole room after the owner changed the rules of the game mode
+type RoomSettingsMessage struct {
+	GameModeInfo map[string]string `json:"gameModeInfo"`
+	Clients      []ClientData      `json:"clients"`
+}
+
+// One entry of the room browser, a MaxPlayerCount of 0 means there is no limit
+type RoomInfo struct {
+	RoomId         string `json:"roomId"`
+	SceneIndex     string `json:"sceneIndex"`
+	GameModeType   string `json:"gameModeType"`
+	PlayerCount    int    `json:"playerCount"`
+	MaxPlayerCount int    `json:"maxPlayerCount"`
+	HasStarted     bool   `json:"hasStarted"`
+	HasPassword    bool   `json:"hasPassword"`
+}
+
+// What the /stats endpoint shows about every player in a room
+type PlayerStats struct {
+	RoomId             string `json:"roomId"`
+	Id                 int    `json:"id"`
+	DroppedPackets     int    `json:"droppedPackets"`
+	ReorderedPackets   int    `json:"reorderedPackets"`
+	MovementViolations int    `json:"movementViolations"`
+	SuspiciousShots    int    `json:"suspiciousShots"`
+	RejectedHits       int    `json:"rejectedHits"`
+}
+
+type RoomListMessage struct {
+	Rooms []RoomInfo `json:"rooms"`
 }
 
 type RejoinMessage struct {
-	playerId  int
-	newHealth int
+	PlayerId  int `json:"playerId,string"`
+	NewHealth int `json:"newHealth,string"`
 }
 
 type BulletShotMessage struct {
-	bulletType           string
-	shooter              string
-	gunIndex             string
-	velocity             string
-	planeFacingDirection string
+	BulletType           string    `json:"bulletType"`
+	Shooter              string    `json:"shooter"`
+	GunIndex             string    `json:"gunIndex"`
+	Velocity             []float64 `json:"velocity"`
+	PlaneFacingDirection []float64 `json:"planeFacingDirection"`
 }
 
-//"{\"type\":\"rocketShot\", \"rocketType\":\""+rocketType+"\", \"shooter\":\""+shooter+"\", \"startPos\":"+string(startPosVal)+", \"velocity\":"+string(velocityVal)+", \"facingAngle\":"+string(planeFacingDirectionVal)+", \"targetId\":\""+target+"\"}"
-
+// RocketId is only set for rockets the server flies, they end with a rocketHit or rocketExpired message
 type RocketShotMessage struct {
-	rocketType  string
-	shooter     string
-	gunIndex    string
-	velocity    string
-	facingAngle string
-	targetId    string
+	RocketId    int       `json:"rocketId,omitempty"`
+	RocketType  string    `json:"rocketType"`
+	Shooter     string    `json:"shooter"`
+	GunIndex    string    `json:"gunIndex"`
+	Velocity    []float64 `json:"velocity"`
+	FacingAngle []float64 `json:"facingAngle"`
+	TargetId    string    `json:"targetId"`
+}
+
+type RocketHitMessage struct {
+	RocketId    int       `json:"rocketId"`
+	Shooter     int       `json:"shooter,string"`
+	HitPlayerId int       `json:"hitPlayerId,string"`
+	Position    []float64 `json:"position"`
+}
+
+type RocketExpiredMessage struct {
+	RocketId int       `json:"rocketId"`
+	Position []float64 `json:"position"`
 }
 
-func (m RocketShotMessage) getMessageJSON() string {
-	return "{\"type\":\"rocketShot\", \"rocketType\":\"" + m.rocketType + "\", \"shooter\":\"" + m.shooter + "\", \"gunIndex\":\"" + m.gunIndex + "\", \"velocity\":" + m.velocity + ", \"facingAngle\":" + m.facingAngle + ", \"targetId\":\"" + m.targetId + "\"}"
+type PlayerHitMessage struct {
+	HitPlayerId int `json:"hitPlayerId,string"`
+	NewHealth   int `json:"newHealth,string"`
+}
 
+type PlayerDiedMessage struct {
+	DeadPlayer int `json:"deadPlayer,string"`
+	Killer     int `json:"killer,string"`
 }
 
-func (m BulletShotMessage) getMessageJSON() string {
-	return "{\"type\":\"bulletShot\", \"bulletType\":\"" + m.bulletType + "\", \"shooter\":\"" + m.shooter + "\", \"gunIndex\":\"" + m.gunIndex + "\", \"velocity\":" + m.velocity + ", \"planeFacingDirection\":" + m.planeFacingDirection + "}"
+type GameOverMessage struct {
+	WinnerType string `json:"winnerType"`
+	Winner     string `json:"winner"`
+	LastKill   int    `json:"lastKill,string"`
 }
 
-func (m RejoinMessage) getMessageJSON() string {
-	return "{\"type\":\"rejoin\", \"playerId\":\"" + strconv.Itoa(m.playerId) + "\", \"newHealth\":\"" + strconv.Itoa(m.newHealth) + "\"}"
+type TransferOwnershipMessage struct {
+	NewOwner string `json:"newOwner"`
 }
 
-func (m JoinSuccessMessage) getMessageJSON() string {
-	return "{\"type\":\"joinSuccess\", \"newRoomId\":\"" + m.newRoomId + "\", \"startHealth\":\"" + strconv.Itoa(m.startHealth) + "\", \"sceneIndex\":\"" + m.sceneIndex + "\", \"gameMode\":\"" + m.gameMode + "\", \"otherClients\":" + m.otherClients + "}"
+// Only sent to the player who was thrown out of the room
+type KickedMessage struct {
+	RoomId string `json:"roomId"`
+	Reason string `json:"reason"`
+	Banned bool   `json:"banned"`
 }
 
-func (m CreatedRoomMessage) getMessageJSON() string {
-	message := "{\"type\":\"createdRoom\", \"newRoomId\":\"" + m.newRoomId + "\", \"startHealth\":\"" + strconv.Itoa(m.startHealth) + "\", \"sceneIndex\":\"" + m.sceneIndex + "\"}"
-	return message
+type UpdatePlayerTransformMessage struct {
+	AllPlayerTransformDict map[int]string `json:"allPlayerTransformDict"`
 }
 
-func (m ClientConnectedMessage) getMessageJSON() string {
-	message := "{\"type\":\"clientConnected\", \"Id\":\"" + strconv.Itoa(m.Id) + "\", \"Name\":\"" + m.Name + "\", \"Team\":\"" + m.Team + "\", \"IsReady\":\"" + strconv.FormatBool(m.IsReady) + "\", \"PlaneTypes\":" + m.PlaneTypes + ", \"PlayerHealth\":\"" + strconv.Itoa(m.PlayerHealth) + "\"}"
-	return message
+// Only holds the transforms that changed since BaseSnapshotId, or all of them if IsKeyframe is set.
+// Big snapshots are split into PartCount datagrams, a snapshot should only be acknowledged once all of them arrived
+type DeltaSnapshotMessage struct {
+	SnapshotId             uint32         `json:"snapshotId"`
+	BaseSnapshotId         uint32         `json:"baseSnapshotId"`
+	IsKeyframe             bool           `json:"isKeyframe"`
+	Part                   int            `json:"part"`
+	PartCount              int            `json:"partCount"`
+	AllPlayerTransformDict map[int]string `json:"allPlayerTransformDict"`
+	RemovedPlayers         []int          `json:"removedPlayers"`
 }
 
-func (m ErrorMessage) getMessageJSON() string {
-	return "{\"type\":\"Error\", \"value\":\"" + m.ErrorText + "\"}"
+func (HelloMessage) messageType() string                 { return "hello" }
+func (ErrorMessage) messageType() string                 { return "Error" }
+func (ClientConnectedMessage) messageType() string       { return "clientConnected" }
+func (ClientDisconnectedMessage) messageType() string    { return "clientDisconnected" }
+func (ClientConnectionLostMessage) messageType() string  { return "clientConnectionLost" }
+func (ClientReconnectedMessage) messageType() string     { return "clientReconnected" }
+func (ResumeStateMessage) messageType() string           { return "resumeState" }
+func (CreatedRoomMessage) messageType() string           { return "createdRoom" }
+func (JoinSuccessMessage) messageType() string           { return "joinSuccess" }
+func (RoomSettingsMessage) messageType() string          { return "roomSettings" }
+func (RoomListMessage) messageType() string              { return "roomList" }
+func (RejoinMessage) messageType() string                { return "rejoin" }
+func (BulletShotMessage) messageType() string            { return "bulletShot" }
+func (RocketShotMessage) messageType() string            { return "rocketShot" }
+func (RocketHitMessage) messageType() string             { return "rocketHit" }
+func (RocketExpiredMessage) messageType() string         { return "rocketExpired" }
+func (PlayerHitMessage) messageType() string             { return "playerHit" }
+func (PlayerDiedMessage) messageType() string            { return "playerDied" }
+func (GameOverMessage) messageType() string              { return "GameOver" }
+func (TransferOwnershipMessage) messageType() string     { return "transferOwnership" }
+func (KickedMessage) messageType() string                { return "kicked" }
+func (DeltaSnapshotMessage) messageType() string         { return "transformSnapshot" }
+func (UpdatePlayerTransformMessage) messageType() string { return "updatePlayerTransform" }
+
+func encodeMessage(m outboundMessage) string {
+	body, err := json.Marshal(m)
+	if err != nil {
+		fmt.Println("Error encoding " + m.messageType() + " message: " + err.Error())
+		return ""
+	}
+	//Putting the type in front of the fields of the message
+	message := "{\"type\":" + strconv.Quote(m.messageType())
+	if len(body) > 2 {
+		message += ","
+	}
+	return message + string(body[1:])
 }
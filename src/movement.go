@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+func getMaxSpeed(planeTypes string) float64 { //The fastest plane the player can fly decides how fast it may move
+	var types []string
+	json.Unmarshal([]byte(planeTypes), &types)
+	maxSpeed := 0.0
+	isListed := false
+	for _, planeType := range types {
+		if speed, ok := maxPlaneSpeeds[planeType]; ok && (!isListed || speed > maxSpeed) {
+			maxSpeed = speed
+			isListed = true
+		}
+	}
+	//The default is only for players who have no plane with a speed of its own
+	if !isListed {
+		return maxPlaneSpeeds["default"]
+	}
+	return maxSpeed
+}
+
+func (room *RoomBase) checkMovement(p *Player, transform Transform, now time.Time) (Transform, bool) { //Clamps or rejects moves that are faster than the plane of the player can fly
+	//Players can move freely while nobody is flying yet, and the first position after spawning can't be checked
+	if room.isOpen || !p.hasPosition {
+		p.hasPosition = true
+		return transform, true
+	}
+	//Datagrams often arrive in bursts, so a short gap is treated like a full tick
+	elapsed := now.Sub(p.transform.Timestamp).Seconds()
+	if minElapsed := 1 / float64(transformTickRate); elapsed < minElapsed {
+		elapsed = minElapsed
+	}
+	allowedDistance := getMaxSpeed(p.planeTypes) * speedTolerance * elapsed
+	distance := transform.distanceTo(p.transform)
+	if distance > allowedDistance {
+		p.movementViolations++
+		fmt.Println("Player", p.playerId, "moved", distance, "units in", elapsed, "seconds but may only move", allowedDistance, "violations:", p.movementViolations)
+		if movementPolicy != "clamp" {
+			return transform, false
+		}
+		var clamped [3]float64
+		for i := range clamped {
+			clamped[i] = p.transform.Position[i] + (transform.Position[i]-p.transform.Position[i])/distance*allowedDistance
+		}
+		transform.setPosition(clamped)
+	}
+	return transform, true
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// Close codes for connections that fail the hello handshake
+const CLOSE_HANDSHAKE_FAILED = 4000
+const CLOSE_INCOMPATIBLE_PROTOCOL = 4001
+
 // We'll need to define an Upgrader
 // this will require a Read and Write buffer size
 var upgrader = websocket.Upgrader{
@@ -21,14 +29,67 @@ func homePage(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Home Page")
 }
 
-func tcpReader(conn *websocket.Conn) {
+func roomsEndpoint(w http.ResponseWriter, r *http.Request) { //The room browser for clients that aren't connected yet
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getPublicRooms())
+}
+
+func statsEndpoint(w http.ResponseWriter, r *http.Request) { //Only for the admins of the server
+	if len(statsToken) == 0 || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Stats-Token")), []byte(statsToken)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getPlayerStats())
+}
+
+func tcpReader(conn *websocket.Conn, sender *Player, closed chan bool) {
+	//Stopping the write pump of the connection as soon as nothing can be read from it anymore
+	defer close(closed)
+	//Taking the player out of its room if it didn't leave on its own
+	defer handleLostConnection(sender)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		sender.latency.pongReceived()
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
 	for {
 		_, p, err := conn.ReadMessage()
 		if err != nil {
 			log.Println(err)
 			return
 		}
-		decodeClientMessageOnTCP(p)
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		decodeClientMessageOnTCP(sender, p)
+	}
+}
+
+func writePump(conn *websocket.Conn, outbound chan []byte, closed chan bool, latency *latencyTracker) {
+	//This is the only goroutine writing to the connection, so a slow client only ever blocks itself
+	pingTicker := time.NewTicker(pingPeriod)
+	defer pingTicker.Stop()
+	for {
+		select {
+		case message := <-outbound:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Println(err)
+				conn.Close()
+				return
+			}
+		case <-pingTicker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Println(err)
+				conn.Close()
+				return
+			}
+			latency.pingSent()
+		case <-closed:
+			return
+		}
 	}
 }
 
@@ -44,19 +105,61 @@ func wsEndpoint(w http.ResponseWriter, r *http.Request) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
+		return
 	}
-	handleNewPlayer(ws)
-	if err != nil {
-		log.Println(err)
+	hello, ok := readHello(ws)
+	if !ok {
+		return
 	}
+	outbound := make(chan []byte, sendQueueSize)
+	closed := make(chan bool)
+	latency := &latencyTracker{}
+	go writePump(ws, outbound, closed, latency)
+	sender := handleNewPlayer(ws, outbound, hello)
+	sender.latency = latency
 	// listen indefinitely for new messages coming
 	// through on our WebSocket connection
-	go tcpReader(ws)
+	go tcpReader(ws, sender, closed)
+}
+
+func readHello(conn *websocket.Conn) (*HelloRequest, bool) { //Waits for the hello of a new client and turns it away if it can't talk to this server
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	_, p, err := conn.ReadMessage()
+	if err != nil {
+		log.Println(err)
+		conn.Close()
+		return nil, false
+	}
+	conn.SetReadDeadline(time.Time{})
+	var hello HelloRequest
+	if json.Unmarshal(p, &hello) != nil || hello.Type != "hello" {
+		rejectConnection(conn, CLOSE_HANDSHAKE_FAILED, "The first message has to be a hello message")
+		return nil, false
+	}
+	version := int(hello.ProtocolVersion)
+	if version < MIN_PROTOCOL_VERSION {
+		rejectConnection(conn, CLOSE_INCOMPATIBLE_PROTOCOL, "Your game is outdated, the server needs protocol version "+strconv.Itoa(MIN_PROTOCOL_VERSION)+" or newer but you have "+strconv.Itoa(version))
+		return nil, false
+	}
+	if version > PROTOCOL_VERSION {
+		rejectConnection(conn, CLOSE_INCOMPATIBLE_PROTOCOL, "The server is outdated, it only knows protocol version "+strconv.Itoa(PROTOCOL_VERSION)+" but you have "+strconv.Itoa(version))
+		return nil, false
+	}
+	return &hello, true
+}
+
+func rejectConnection(conn *websocket.Conn, closeCode int, reason string) { //Only used before the write pump of the connection is running
+	fmt.Println("Rejected a connection: " + reason)
+	conn.WriteMessage(websocket.TextMessage, []byte(encodeMessage(ErrorMessage{ErrorText: reason})))
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, reason), time.Now().Add(time.Second))
+	conn.Close()
 }
 
 func setupRoutes() {
 	http.HandleFunc("/", homePage)
 	http.HandleFunc("/ws", wsEndpoint)
+	http.HandleFunc("/rooms", roomsEndpoint)
+	http.HandleFunc("/stats", statsEndpoint)
 }
 
 func startTCP() {
@@ -73,67 +176,53 @@ func startUDP() {
 		log.Fatal(err)
 	}
 	defer pc.Close()
+	//One buffer for every read, each datagram gets its own right-sized copy
+	buf := make([]byte, 65535)
 	for {
-		buf := make([]byte, 1024)
 		n, addr, err := pc.ReadFrom(buf)
 		if err != nil {
 			continue
 		}
-		go updReader(pc, addr, buf[:n])
+		go updReader(pc, addr, append([]byte(nil), buf[:n]...))
 		//time.Sleep(10 * time.Millisecond)
 	}
 }
 
 func sendTCP(p *Player, message string) error {
-	mutex.Lock()
-	defer mutex.Unlock()
-	if p.websocket == nil {
+	if p.websocket == nil || p.outbound == nil || len(message) == 0 {
 		return nil
-	} else {
-		return p.websocket.WriteMessage(1, []byte(message))
+	}
+	select {
+	case p.outbound <- []byte(message):
+		return nil
+	default:
+		//The client isn't keeping up with the messages it gets sent
+		if slowClientPolicy == "disconnect" {
+			fmt.Println("Send queue of player " + p.playerId + " is full, closing the connection")
+			p.websocket.Close()
+		} else {
+			fmt.Println("Send queue of player " + p.playerId + " is full, dropping a message")
+		}
+		return errors.New("send queue of player " + p.playerId + " is full")
 	}
 }
 
 func sendUDP(p *Player, message string) {
-	mutex.Lock()
-	defer mutex.Unlock()
 	if p.udpConn != nil {
 		p.udpConn.WriteTo([]byte(message), p.udpAddr)
 	}
 }
 
-func broadcastTCP(roomId string, message string) {
-	connectedPlayers := make(map[int]Player)
-	mutex.Lock()
-	if _, exists := rooms[roomId]; exists {
-		for key, value := range rooms[roomId].players {
-			if value.websocket != nil {
-				connectedPlayers[key] = value
-			}
-		}
-	} else {
-		fmt.Println("No such room (", roomId, ") found in broadcastTCP()")
-	}
-	mutex.Unlock()
-	for _, v := range connectedPlayers {
+func (room *RoomBase) broadcastTCP(message string) {
+	for _, v := range room.players {
 		if v.websocket != nil {
 			sendTCP(&v, message)
 		}
 	}
 }
 
-func broadcastUDP(roomId string, message string) {
-	connectedPlayers := make(map[int]Player)
-	mutex.Lock()
-	if _, exists := rooms[roomId]; exists {
-		for key, value := range rooms[roomId].players {
-			connectedPlayers[key] = value
-		}
-	} else {
-		fmt.Println("No such room (" + roomId + ") found")
-	}
-	mutex.Unlock()
-	for _, v := range connectedPlayers {
+func (room *RoomBase) broadcastUDP(message string) {
+	for _, v := range room.players {
 		sendUDP(&v, message)
 	}
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Clients send numbers and booleans sometimes as JSON strings and sometimes as plain values,
+// these types accept both
+type flexString string
+type flexInt int
+type flexFloat float64
+type flexBool bool
+
+func (s *flexString) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*s = ""
+		return nil
+	}
+	if strings.HasPrefix(string(b), "\"") {
+		var value string
+		if err := json.Unmarshal(b, &value); err != nil {
+			return err
+		}
+		*s = flexString(value)
+		return nil
+	}
+	if strings.HasPrefix(string(b), "{") || strings.HasPrefix(string(b), "[") {
+		return errors.New("expected a string but got " + string(b))
+	}
+	*s = flexString(b)
+	return nil
+}
+
+func (i *flexInt) UnmarshalJSON(b []byte) error {
+	var f flexFloat
+	if err := f.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	*i = flexInt(f)
+	return nil
+}
+
+func (f *flexFloat) UnmarshalJSON(b []byte) error {
+	value := strings.Trim(string(b), "\"")
+	if value == "null" || len(value) == 0 {
+		*f = 0
+		return nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return errors.New("expected a number but got " + string(b))
+	}
+	*f = flexFloat(parsed)
+	return nil
+}
+
+func (v *flexBool) UnmarshalJSON(b []byte) error {
+	value := strings.Trim(string(b), "\"")
+	if value == "null" || len(value) == 0 {
+		*v = false
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return errors.New("expected a boolean but got " + string(b))
+	}
+	*v = flexBool(parsed)
+	return nil
+}
+
+func (i flexInt) String() string {
+	return strconv.Itoa(int(i))
+}
+
+// Requests can check their fields after decoding by implementing this
+type validatedRequest interface {
+	validate() error
+}
+
+type MessageEnvelope struct {
+	Type string `json:"type"`
+}
+
+// The first message every client has to send after connecting
+type HelloRequest struct {
+	Type            string     `json:"type"`
+	ProtocolVersion flexInt    `json:"protocolVersion"`
+	Capabilities    []string   `json:"capabilities"`
+	ResumeToken     flexString `json:"resumeToken"`
+}
+
+type CreateRoomRequest struct {
+	PlayerId     flexInt                `json:"playerId"`
+	Name         flexString             `json:"name"`
+	PlaneTypes   json.RawMessage        `json:"planeTypes"`
+	WorldIndex   flexString             `json:"worldIndex"`
+	GameModeInfo map[string]interface{} `json:"gameModeInfo"`
+	IsPublic     flexBool               `json:"isPublic"`
+	Password     flexString             `json:"password"`
+}
+
+type JoinRoomRequest struct {
+	PlayerId   flexInt         `json:"playerId"`
+	RoomId     flexString      `json:"roomId"`
+	Name       flexString      `json:"name"`
+	PlaneTypes json.RawMessage `json:"planeTypes"`
+	Password   flexString      `json:"password"`
+}
+
+type ListRoomsRequest struct{}
+
+// Used for every message that only needs to know the room
+type RoomRequest struct {
+	RoomId flexString `json:"roomId"`
+}
+
+type ChangeTeamRequest struct {
+	RoomId flexString `json:"roomId"`
+	Id     flexInt    `json:"Id"`
+	Team   flexString `json:"Team"`
+}
+
+// An empty target means the player lost its lock
+type TargetLockedRequest struct {
+	RoomId flexString `json:"roomId"`
+	Target flexString `json:"target"`
+}
+
+type ReadyRequest struct {
+	RoomId flexString `json:"roomId"`
+	Id     flexInt    `json:"Id"`
+}
+
+type ChangeSettingsRequest struct {
+	RoomId       flexString             `json:"roomId"`
+	GameModeInfo map[string]interface{} `json:"gameModeInfo"`
+}
+
+type RejoinRequest struct {
+	PlayerId flexInt    `json:"playerId"`
+	RoomId   flexString `json:"roomId"`
+}
+
+type ShootBulletRequest struct {
+	RoomId               flexString  `json:"roomId"`
+	BulletType           flexString  `json:"bulletType"`
+	Shooter              flexInt     `json:"shooter"`
+	GunIndex             flexString  `json:"gunIndex"`
+	Velocity             []flexFloat `json:"velocity"`
+	PlaneFacingDirection []flexFloat `json:"planeFacingDirection"`
+}
+
+type ShootRocketRequest struct {
+	RoomId               flexString  `json:"roomId"`
+	RocketType           flexString  `json:"rocketType"`
+	Shooter              flexInt     `json:"shooter"`
+	Target               flexString  `json:"target"`
+	GunIndex             flexString  `json:"gunIndex"`
+	Velocity             []flexFloat `json:"velocity"`
+	PlaneFacingDirection []flexFloat `json:"planeFacingDirection"`
+}
+
+// Sent by the shooter, the damage comes from the weapon tables and not from the client
+type PlayerHitRequest struct {
+	RoomId     flexString `json:"roomId"`
+	PlayerId   flexInt    `json:"playerId"`
+	ShooterId  flexInt    `json:"shooterId"`
+	BulletType flexString `json:"bulletType"`
+	RocketType flexString `json:"rocketType"`
+	//The rocketId of the rocketShot message, it is 0 for rockets the server doesn't fly
+	RocketId flexInt `json:"rocketId"`
+	//The snapshot the shooter saw when it fired is optional, where it hit the target is not
+	SnapshotId  flexInt     `json:"snapshotId"`
+	HitPosition []flexFloat `json:"hitPosition"`
+}
+
+// Only accepted for suicides, the server finds out about every other death on its own
+type PlayerDiedRequest struct {
+	RoomId    flexString `json:"roomId"`
+	PlayerId  flexInt    `json:"playerId"`
+	IsSuicide flexBool   `json:"isSuicide"`
+}
+
+type ClientDisconnectedRequest struct {
+	RoomId flexString `json:"roomId"`
+	Id     flexInt    `json:"Id"`
+}
+
+type TransferOwnershipRequest struct {
+	RoomId   flexString `json:"roomId"`
+	NewOwner flexInt    `json:"newOwner"`
+}
+
+// Used for kickPlayer and banPlayer
+type KickPlayerRequest struct {
+	RoomId   flexString `json:"roomId"`
+	TargetId flexInt    `json:"targetId"`
+	Reason   flexString `json:"reason"`
+}
+
+type CompleteDeleteRequest struct {
+	RoomId   flexString `json:"roomId"`
+	PlayerId flexInt    `json:"playerId"`
+}
+
+func (r *ShootBulletRequest) validate() error {
+	if r.Velocity == nil || r.PlaneFacingDirection == nil {
+		return errors.New("velocity and planeFacingDirection are required")
+	}
+	return nil
+}
+
+func (r *ShootRocketRequest) validate() error {
+	if r.Velocity == nil || r.PlaneFacingDirection == nil {
+		return errors.New("velocity and planeFacingDirection are required")
+	}
+	return nil
+}
+
+func (r *PlayerHitRequest) validate() error {
+	if len(r.BulletType) == 0 && len(r.RocketType) == 0 {
+		return errors.New("bulletType or rocketType is required")
+	}
+	return nil
+}
+
+// Decodes the raw message into a new T before handing it to the handler
+type tcpHandler func(sender *Player, message_raw []byte) error
+
+func handle[T any](handler func(sender *Player, request *T, message_raw []byte)) tcpHandler {
+	return func(sender *Player, message_raw []byte) error {
+		request := new(T)
+		if err := json.Unmarshal(message_raw, request); err != nil {
+			return err
+		}
+		if v, ok := interface{}(request).(validatedRequest); ok {
+			if err := v.validate(); err != nil {
+				return err
+			}
+		}
+		handler(sender, request, message_raw)
+		return nil
+	}
+}
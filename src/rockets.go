@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// A rocket the server flies itself, so every client agrees on whom it hit
+type rocket struct {
+	id         int
+	rocketType string
+	shooterId  int
+	targetId   int
+	hasTarget  bool
+	position   [3]float64
+	direction  [3]float64
+	stats      WeaponStats
+	expiresAt  time.Time
+}
+
+func (room *RoomBase) launchRocket(shooter Player, shooterId int, rocketType string, stats WeaponStats, direction []float64, target string) (int, bool) { //Only rockets with a speed and a shooter whose position is known are simulated
+	if stats.Speed <= 0 || !shooter.hasTransform {
+		return 0, false
+	}
+	var r rocket
+	copy(r.direction[:], direction)
+	if !normalize(&r.direction) {
+		return 0, false
+	}
+	room.nextRocketId++
+	r.id = room.nextRocketId
+	r.rocketType = rocketType
+	r.shooterId = shooterId
+	r.position = shooter.transform.Position
+	r.stats = stats
+	r.expiresAt = time.Now().Add(time.Duration(stats.LifetimeMs) * time.Millisecond)
+	if targetId, err := strconv.Atoi(target); err == nil && targetId != shooterId {
+		r.targetId, r.hasTarget = targetId, true
+	}
+	room.rockets[r.id] = &r
+	return r.id, true
+}
+
+func (room *RoomBase) updateRockets(now time.Time) { //Moves every rocket one tick further and lets it explode or run out
+	elapsed := 1 / float64(transformTickRate)
+	for id, r := range room.rockets {
+		if !now.Before(r.expiresAt) {
+			delete(room.rockets, id)
+			room.broadcastTCP(encodeMessage(RocketExpiredMessage{RocketId: id, Position: r.position[:]}))
+			continue
+		}
+		//Turning toward the newest transform of the target, but not faster than the rocket can
+		if target, ok := room.players[r.targetId]; r.hasTarget && ok && target.hasTransform && !target.isDead {
+			var wanted [3]float64
+			for i := range wanted {
+				wanted[i] = target.transform.Position[i] - r.position[i]
+			}
+			if normalize(&wanted) {
+				r.direction = turnToward(r.direction, wanted, r.stats.TurnRate*math.Pi/180*elapsed)
+			}
+		}
+		start := r.position
+		for i := range r.position {
+			r.position[i] += r.direction[i] * r.stats.Speed * elapsed
+		}
+		if hitPlayerId, ok := room.findRocketHit(r, start); ok {
+			delete(room.rockets, id)
+			fmt.Println("Rocket", id, "of player", r.shooterId, "hit player", hitPlayerId)
+			rhm := RocketHitMessage{
+				RocketId:    id,
+				Shooter:     r.shooterId,
+				HitPlayerId: hitPlayerId,
+				Position:    r.position[:],
+			}
+			room.broadcastTCP(encodeMessage(rhm))
+			room.damagePlayer(hitPlayerId, r.shooterId, r.stats.Damage)
+		}
+	}
+}
+
+// A rocket the server couldn't fly, the shooter reports what it hit with a playerHit instead
+type unflownRocket struct {
+	rocketType string
+	shooterId  int
+	expiresAt  time.Time
+}
+
+func (room *RoomBase) addUnflownRocket(shooterId int, rocketType string, stats WeaponStats) {
+	lifetime := shotLifetime
+	if stats.LifetimeMs > 0 {
+		lifetime = time.Duration(stats.LifetimeMs) * time.Millisecond
+	}
+	room.dropExpiredUnflownRockets(time.Now())
+	room.unflownRockets = append(room.unflownRockets, unflownRocket{rocketType: rocketType, shooterId: shooterId, expiresAt: time.Now().Add(lifetime)})
+}
+
+func (room *RoomBase) useUnflownRocket(shooterId int, rocketType string) bool { //Every unflown rocket can only hit once, hits without one count as rejected
+	room.dropExpiredUnflownRockets(time.Now())
+	for i, r := range room.unflownRockets {
+		if r.shooterId == shooterId && r.rocketType == rocketType {
+			room.unflownRockets = append(room.unflownRockets[:i], room.unflownRockets[i+1:]...)
+			return true
+		}
+	}
+	if shooter, ok := room.players[shooterId]; ok {
+		shooter.rejectedHits++
+		fmt.Println("Player", shooterId, "hit someone with a", rocketType, "rocket that the server flies or never saw, rejected hits:", shooter.rejectedHits)
+		room.players[shooterId] = shooter
+	}
+	return false
+}
+
+func (room *RoomBase) dropExpiredUnflownRockets(now time.Time) {
+	left := room.unflownRockets[:0]
+	for _, r := range room.unflownRockets {
+		if now.Before(r.expiresAt) {
+			left = append(left, r)
+		}
+	}
+	room.unflownRockets = left
+}
+
+func (room *RoomBase) findRocketHit(r *rocket, start [3]float64) (int, bool) { //Checks the whole way the rocket flew this tick so fast rockets can't fly through a plane
+	for playerId, p := range room.players {
+		if playerId == r.shooterId || !p.hasTransform || p.isDead {
+			continue
+		}
+		if distanceToSegment(p.transform.Position, start, r.position) <= r.stats.HitRadius {
+			return playerId, true
+		}
+	}
+	return 0, false
+}
+
+func normalize(v *[3]float64) bool {
+	length := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	if length == 0 || math.IsNaN(length) || math.IsInf(length, 0) {
+		return false
+	}
+	for i := range v {
+		v[i] /= length
+	}
+	return true
+}
+
+func turnToward(from [3]float64, to [3]float64, maxAngle float64) [3]float64 { //Both directions have to be normalized
+	dot := math.Max(-1, math.Min(1, from[0]*to[0]+from[1]*to[1]+from[2]*to[2]))
+	angle := math.Acos(dot)
+	if angle <= maxAngle {
+		return to
+	}
+	//The target is straight behind the rocket, there is no way to tell which side to turn to
+	if math.Sin(angle) < 1e-6 {
+		return from
+	}
+	//Spherical interpolation between both directions
+	progress := maxAngle / angle
+	fromWeight := math.Sin((1-progress)*angle) / math.Sin(angle)
+	toWeight := math.Sin(progress*angle) / math.Sin(angle)
+	var result [3]float64
+	for i := range result {
+		result[i] = from[i]*fromWeight + to[i]*toWeight
+	}
+	normalize(&result)
+	return result
+}
+
+func distanceToSegment(point [3]float64, start [3]float64, end [3]float64) float64 {
+	var segment, toPoint [3]float64
+	lengthSquared := 0.0
+	for i := range segment {
+		segment[i] = end[i] - start[i]
+		toPoint[i] = point[i] - start[i]
+		lengthSquared += segment[i] * segment[i]
+	}
+	progress := 0.0
+	if lengthSquared > 0 {
+		progress = (toPoint[0]*segment[0] + toPoint[1]*segment[1] + toPoint[2]*segment[2]) / lengthSquared
+		progress = math.Max(0, math.Min(1, progress))
+	}
+	distance := 0.0
+	for i := range segment {
+		offset := toPoint[i] - segment[i]*progress
+		distance += offset * offset
+	}
+	return math.Sqrt(distance)
+}
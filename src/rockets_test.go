@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnflownRockets(t *testing.T) {
+	room := newRoom("1", map[string]string{}, []string{"red"})
+	room.players[1] = Player{playerId: "1"}
+	stats := WeaponStats{LifetimeMs: 1000}
+	room.addUnflownRocket(1, "hellfire", stats)
+	if room.useUnflownRocket(1, "sidewinder") || room.useUnflownRocket(2, "hellfire") {
+		t.Fatal("the rocket was used by another rocket type or shooter")
+	}
+	if !room.useUnflownRocket(1, "hellfire") {
+		t.Fatal("the rocket should be able to hit")
+	}
+	if room.useUnflownRocket(1, "hellfire") {
+		t.Fatal("the rocket hit twice")
+	}
+	room.addUnflownRocket(1, "hellfire", stats)
+	room.unflownRockets[0].expiresAt = time.Now().Add(-time.Millisecond)
+	if room.useUnflownRocket(1, "hellfire") {
+		t.Fatal("the rocket hit after its lifetime")
+	}
+	if rejected := room.players[1].rejectedHits; rejected != 3 {
+		t.Fatalf("expected 3 rejected hits but got %d", rejected)
+	}
+}
+
+func TestLaunchRocketNeedsAPosition(t *testing.T) {
+	room := newRoom("1", map[string]string{}, []string{"red"})
+	stats := WeaponStats{Speed: 100, LifetimeMs: 1000}
+	if _, ok := room.launchRocket(Player{}, 1, "hellfire", stats, []float64{0, 0, 1}, ""); ok {
+		t.Fatal("a rocket was launched without knowing where the shooter is")
+	}
+	shooter := Player{hasTransform: true}
+	if _, ok := room.launchRocket(shooter, 1, "hellfire", stats, []float64{0, 0, 0}, ""); ok {
+		t.Fatal("a rocket was launched without a direction")
+	}
+	if rocketId, ok := room.launchRocket(shooter, 1, "hellfire", stats, []float64{0, 0, 1}, "2"); !ok || room.rockets[rocketId].targetId != 2 {
+		t.Fatal("the rocket should have been launched at player 2")
+	}
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Every room is an actor: all of its state is only ever touched by the goroutine started in registerRoom,
+// everybody else hands it tasks through the inbox
+type RoomBase struct {
+	roomId         string
+	sceneIndex     string
+	roomRules      map[string]string
+	availableTeams []string
+	players        map[int]Player
+	isOpen         bool
+	isPublic       bool
+	passwordSalt   string
+	passwordHash   string
+	ownerId        int
+	bannedIds      map[int]bool
+	snapshotId     uint32
+	snapshotTimes  map[uint32]time.Time
+	rockets        map[int]*rocket
+	unflownRockets []unflownRocket
+	nextRocketId   int
+	//Kills stay with the team even if the player who made them leaves
+	teamKills map[string]int
+	hasClosed bool
+	inbox     chan func(room *RoomBase)
+	closed    chan bool
+}
+
+// The registry is only used to look rooms up by their Id
+var rooms = map[string]*RoomBase{}
+var roomsMutex = &sync.Mutex{}
+
+func newRoom(sceneIndex string, roomRules map[string]string, availableTeams []string) *RoomBase {
+	return &RoomBase{
+		sceneIndex:     sceneIndex,
+		roomRules:      roomRules,
+		availableTeams: availableTeams,
+		players:        map[int]Player{},
+		isOpen:         true,
+		bannedIds:      map[int]bool{},
+		snapshotTimes:  map[uint32]time.Time{},
+		rockets:        map[int]*rocket{},
+		teamKills:      map[string]int{},
+		inbox:          make(chan func(room *RoomBase)),
+		closed:         make(chan bool),
+	}
+}
+
+func registerRoom(room *RoomBase) string { //Gives the room an unused Id, makes it findable and starts its goroutine
+	roomsMutex.Lock()
+	room.roomId = getRandomRoomId()
+	rooms[room.roomId] = room
+	roomsMutex.Unlock()
+	go room.run()
+	return room.roomId
+}
+
+func getRoom(roomId string) (*RoomBase, bool) {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+	room, ok := rooms[roomId]
+	return room, ok
+}
+
+func getAllRooms() []*RoomBase { //Rooms can close while the caller goes through them, do() tells when that happened
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+	allRooms := []*RoomBase{}
+	for _, room := range rooms {
+		allRooms = append(allRooms, room)
+	}
+	return allRooms
+}
+
+func getPublicRooms() []RoomInfo { //Collects what the room browser shows about every public room
+	publicRooms := []RoomInfo{}
+	for _, room := range getAllRooms() {
+		room.do(func(room *RoomBase) {
+			if room.isPublic {
+				publicRooms = append(publicRooms, room.getRoomInfo())
+			}
+		})
+	}
+	return publicRooms
+}
+
+func getPlayerStats() []PlayerStats { //Collects the network and anti cheat counters of every player in a room
+	allStats := []PlayerStats{}
+	for _, room := range getAllRooms() {
+		room.do(func(room *RoomBase) {
+			for playerId, p := range room.players {
+				allStats = append(allStats, PlayerStats{
+					RoomId:             room.roomId,
+					Id:                 playerId,
+					DroppedPackets:     p.droppedPackets,
+					ReorderedPackets:   p.reorderedPackets,
+					MovementViolations: p.movementViolations,
+					SuspiciousShots:    p.suspiciousShots,
+					RejectedHits:       p.rejectedHits,
+				})
+			}
+		})
+	}
+	return allStats
+}
+
+func withRoom(roomId string, task func(room *RoomBase)) bool { //Runs the task inside the room with that Id and waits for it to finish
+	room, ok := getRoom(roomId)
+	if !ok || !room.do(task) {
+		fmt.Println("No such room (", roomId, ") found")
+		return false
+	}
+	return true
+}
+
+func (room *RoomBase) run() {
+	ticker := time.NewTicker(time.Second / time.Duration(transformTickRate))
+	defer ticker.Stop()
+	for {
+		select {
+		case task := <-room.inbox:
+			task(room)
+			if room.hasClosed {
+				return
+			}
+		case <-ticker.C:
+			room.dropSilentPlayers()
+			if room.hasClosed {
+				return
+			}
+			room.updateRockets(time.Now())
+			//Sending one snapshot of all transforms in the room per tick
+			room.updateClientTransforms()
+		}
+	}
+}
+
+func (room *RoomBase) do(task func(room *RoomBase)) bool { //Returns false if the room was closed before it could run the task
+	done := make(chan bool)
+	select {
+	case room.inbox <- func(room *RoomBase) {
+		task(room)
+		close(done)
+	}:
+		<-done
+		return true
+	case <-room.closed:
+		return false
+	}
+}
+
+func (room *RoomBase) close() { //Must only be called from inside the room
+	roomsMutex.Lock()
+	if rooms[room.roomId] == room {
+		delete(rooms, room.roomId)
+	}
+	roomsMutex.Unlock()
+	room.hasClosed = true
+	close(room.closed)
+}
+
+func (room *RoomBase) removePlayer(playerId int) {
+	room.broadcastTCP(encodeMessage(ClientDisconnectedMessage{Id: playerId}))
+	if leavingPlayer, ok := room.players[playerId]; ok {
+		putPlayerIntoLobby(playerId, leavingPlayer)
+	}
+	delete(room.players, playerId)
+	//Deleting the room if nobody is in it anymore
+	if len(room.players) == 0 {
+		room.close()
+	}
+}
+
+func (room *RoomBase) disconnectPlayer(playerId int) { //Removes the player and picks a new owner if it owned the room
+	wasOwner := playerId == room.ownerId
+	room.removePlayer(playerId)
+	if !room.hasClosed && wasOwner {
+		room.pickNewOwner()
+	}
+}
+
+func (room *RoomBase) pickNewOwner() {
+	newOwner := ""
+	for _, v := range room.players {
+		//Players who are only waiting to resume their session are taken if nobody else is left
+		if v.websocket != nil || len(newOwner) == 0 {
+			newOwner = v.playerId
+		}
+		if v.websocket != nil {
+			break
+		}
+	}
+	room.ownerId, _ = strconv.Atoi(newOwner)
+	fmt.Println("Player", newOwner, "is now the owner of room", room.roomId)
+	room.broadcastTCP(encodeMessage(TransferOwnershipMessage{NewOwner: newOwner}))
+}
+
+func (room *RoomBase) isOwner(p *Player) bool { //Tells the player off if it tries to do something only the owner may do
+	if p.playerId == strconv.Itoa(room.ownerId) {
+		return true
+	}
+	fmt.Println("Player " + p.playerId + " tried to do something only the owner of room " + room.roomId + " may do")
+	em := ErrorMessage{
+		ErrorText: "Only the owner of the room can do that",
+	}
+	sendTCP(p, encodeMessage(em))
+	return false
+}
+
+func (room *RoomBase) allowShot(shooterId int, weaponKey string, stats WeaponStats) bool { //Checks the fire rate and ammo of the weapon, shots it doesn't allow count as suspicious
+	shooter, ok := room.players[shooterId]
+	if !ok || shooter.isDead {
+		return false
+	}
+	if shooter.weaponStates == nil {
+		shooter.weaponStates = map[string]*weaponState{}
+	}
+	weapon, ok := shooter.weaponStates[weaponKey]
+	if !ok {
+		weapon = &weaponState{shotsLeft: stats.MagazineSize}
+		shooter.weaponStates[weaponKey] = weapon
+	}
+	allowed := weapon.tryShot(stats, time.Now())
+	if !allowed {
+		shooter.suspiciousShots++
+		fmt.Println("Player", shooterId, "fired", weaponKey, "faster than it can, suspicious shots:", shooter.suspiciousShots)
+	}
+	room.players[shooterId] = shooter
+	return allowed
+}
+
+func (room *RoomBase) useShot(shooterId int, weaponKey string, stats WeaponStats) bool { //Hits without a shot of the weapon left to use up count as rejected
+	shooter, ok := room.players[shooterId]
+	if !ok {
+		return false
+	}
+	if weapon, ok := shooter.weaponStates[weaponKey]; ok && weapon.useShot(stats, time.Now()) {
+		return true
+	}
+	shooter.rejectedHits++
+	fmt.Println("Player", shooterId, "hit someone with", weaponKey, "without a shot left to hit with, rejected hits:", shooter.rejectedHits)
+	room.players[shooterId] = shooter
+	return false
+}
+
+func (room *RoomBase) damagePlayer(playerId int, shooterId int, damage int) {
+	shotPlayer, ok := room.players[playerId]
+	if !ok || shotPlayer.isDead {
+		return
+	}
+	shotPlayer.currentHealth -= damage
+	if shotPlayer.currentHealth < 0 {
+		shotPlayer.currentHealth = 0
+	}
+	room.players[playerId] = shotPlayer
+	phm := PlayerHitMessage{
+		HitPlayerId: playerId,
+		NewHealth:   shotPlayer.currentHealth,
+	}
+	room.broadcastTCP(encodeMessage(phm))
+	if shotPlayer.currentHealth == 0 {
+		room.registerKill(playerId, shooterId)
+	}
+}
+
+func (room *RoomBase) registerKill(deadPlayerId int, killerId int) { //Lets everybody know about the death and checks if the killer has won, killerId is the dead player itself for suicides
+	deadPlayer, ok := room.players[deadPlayerId]
+	if !ok {
+		return
+	}
+	deadPlayer.isDead = true
+	deadPlayer.currentHealth = 0
+	room.players[deadPlayerId] = deadPlayer
+	//Updating the kills of the shooter, shooting down a teammate doesn't count
+	hasTeams, _ := strconv.ParseBool(room.roomRules["hasTeams"])
+	if killer, ok := room.players[killerId]; ok && killerId != deadPlayerId && (!hasTeams || killer.currentTeam != deadPlayer.currentTeam) {
+		killer.kills += 1
+		room.players[killerId] = killer
+		if hasTeams {
+			room.teamKills[killer.currentTeam]++
+		}
+		if room.checkTeamVictory(killer.currentTeam, deadPlayerId) {
+			return
+		}
+		//Checking if the room has the rule to win with kills
+		if useKills, _ := strconv.ParseBool(room.roomRules["useKills"]); useKills {
+			fmt.Println("The killer ", killerId, " has now ", killer.kills, " kills and he needs: ", room.roomRules["killsToWin"], " kills")
+			//If it does, checking if the killer has reached the kill Limit
+			if killsToWin, _ := strconv.Atoi(room.roomRules["killsToWin"]); killer.kills >= killsToWin {
+				//If he reached the limit, informing all the clients about the win/loss
+				fmt.Println("Someone has won the game")
+				gom := GameOverMessage{
+					WinnerType: "Single",
+					Winner:     strconv.Itoa(killerId),
+					LastKill:   deadPlayerId,
+				}
+				room.broadcastTCP(encodeMessage(gom))
+				return
+			}
+		}
+	}
+	pdm := PlayerDiedMessage{
+		DeadPlayer: deadPlayerId,
+		Killer:     killerId,
+	}
+	room.broadcastTCP(encodeMessage(pdm))
+}
+
+func (room *RoomBase) checkTeamVictory(team string, lastKill int) bool { //In team games the kills of everybody in the team count together
+	if hasTeams, _ := strconv.ParseBool(room.roomRules["hasTeams"]); !hasTeams {
+		return false
+	}
+	teamKillsToWin, _ := strconv.Atoi(room.roomRules["teamKillsToWin"])
+	if teamKillsToWin <= 0 {
+		return false
+	}
+	teamKills := room.teamKills[team]
+	fmt.Println("Team", team, "has now", teamKills, "kills and needs", teamKillsToWin)
+	if teamKills < teamKillsToWin {
+		return false
+	}
+	fmt.Println("Team", team, "has won the game")
+	gom := GameOverMessage{
+		WinnerType: "Team",
+		Winner:     team,
+		LastKill:   lastKill,
+	}
+	room.broadcastTCP(encodeMessage(gom))
+	return true
+}
+
+func (room *RoomBase) kickPlayer(sender *Player, targetId int, reason string, isBan bool) { //Throws the target out of the room, banned players can't come back until the room closes
+	if !room.isOwner(sender) {
+		return
+	}
+	target, ok := room.players[targetId]
+	if !ok {
+		em := ErrorMessage{
+			ErrorText: "That player is not in the room",
+		}
+		sendTCP(sender, encodeMessage(em))
+		return
+	}
+	if targetId == room.ownerId {
+		em := ErrorMessage{
+			ErrorText: "You can not kick yourself",
+		}
+		sendTCP(sender, encodeMessage(em))
+		return
+	}
+	if isBan {
+		room.bannedIds[targetId] = true
+	}
+	fmt.Println("Player", targetId, "was kicked from room", room.roomId, "banned:", isBan, "reason:", reason)
+	sendTCP(&target, encodeMessage(KickedMessage{RoomId: room.roomId, Reason: reason, Banned: isBan}))
+	room.disconnectPlayer(targetId)
+}
+
+func (room *RoomBase) suspendPlayer(playerId int, conn *websocket.Conn) (bool, bool) { //Keeps the player in the room without a connection until it resumes or its grace period is over
+	p, ok := room.players[playerId]
+	if !ok {
+		return false, false
+	}
+	if p.websocket != conn {
+		return true, true
+	}
+	p.websocket = nil
+	p.outbound = nil
+	p.udpConn = nil
+	p.udpAddr = nil
+	room.players[playerId] = p
+	room.broadcastTCP(encodeMessage(ClientConnectionLostMessage{Id: playerId}))
+	return true, false
+}
+
+func (room *RoomBase) dropSilentPlayers() { //Disconnects players who stopped sending transforms while the game is running
+	if room.isOpen {
+		return
+	}
+	for playerId, p := range room.players {
+		if p.udpAddr != nil && !p.isDead && time.Since(p.lastUDPTime) > udpSilenceTimeout {
+			fmt.Println("Player", playerId, "stopped sending transform updates, disconnecting it")
+			room.disconnectPlayer(playerId)
+			if p.websocket != nil {
+				p.websocket.Close()
+			}
+			if room.hasClosed {
+				return
+			}
+		}
+	}
+}
+
+func (room *RoomBase) updateClientTransforms() {
+	transforms := make(map[int]Transform)
+	for k, v := range room.players {
+		if v.hasTransform && v.websocket != nil && !v.isDead {
+			transforms[k] = v.transform
+		}
+	}
+	room.snapshotId++
+	//Remembering when every snapshot was sent so hits can be checked against it
+	room.snapshotTimes[room.snapshotId] = time.Now()
+	delete(room.snapshotTimes, room.snapshotId-uint32(rewindWindow.Seconds()*float64(transformTickRate)))
+	snapshot := transformSnapshot{snapshotId: room.snapshotId, isKeyframe: true, transforms: transforms}
+	//Players without delta snapshots all get the same datagrams, so they are only encoded once per format
+	fullSnapshots := map[bool][]string{}
+	for playerId, p := range room.players {
+		visible, held := room.getVisibleTransforms(p, transforms)
+		if p.capabilities["deltaSnapshots"] {
+			room.sendSnapshot(playerId, p, room.snapshotId, visible, held)
+			continue
+		}
+		if len(visible) == 0 || p.udpConn == nil {
+			continue
+		}
+		isBinary := p.capabilities["binaryTransforms"]
+		if held != nil {
+			//The player gets its own selection of planes
+			filtered := snapshot
+			filtered.transforms = visible
+			filtered.isKeyframe = false
+			for _, datagram := range filtered.encode(isBinary) {
+				sendUDP(&p, datagram)
+			}
+			continue
+		}
+		if _, ok := fullSnapshots[isBinary]; !ok {
+			fullSnapshots[isBinary] = snapshot.encode(isBinary)
+		}
+		for _, datagram := range fullSnapshots[isBinary] {
+			sendUDP(&p, datagram)
+		}
+	}
+}
+
+func getTeams(roomRules map[string]string) []string { //Reads the teams players can be in out of the rules of the game mode
+	var teams []string
+	if hasTeams, _ := strconv.ParseBool(roomRules["hasTeams"]); hasTeams {
+		teams = strings.Split(strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(roomRules["teamColors"], "[", ""), "]", ""), "\"", ""), " ")
+	} else {
+		teams = append(teams, "All Players")
+	}
+	return teams
+}
+
+func (room *RoomBase) setPassword(password string) { //An empty password leaves the room open to everybody who knows its Id
+	if len(password) == 0 {
+		room.passwordSalt = ""
+		room.passwordHash = ""
+		return
+	}
+	room.passwordSalt = getRandomToken()
+	room.passwordHash = hashPassword(password, room.passwordSalt)
+}
+
+func (room *RoomBase) hasPassword() bool {
+	return len(room.passwordHash) > 0
+}
+
+func (room *RoomBase) checkPassword(password string) bool {
+	if !room.hasPassword() {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(hashPassword(password, room.passwordSalt)), []byte(room.passwordHash)) == 1
+}
+
+func getStartHealth(roomRules map[string]string) int { //Everybody in a room starts with the same health, clients don't get to pick their own
+	if startHealth, err := strconv.Atoi(roomRules["startHealth"]); err == nil && startHealth > 0 {
+		return startHealth
+	}
+	return defaultStartHealth
+}
+
+func (room *RoomBase) getRoomInfo() RoomInfo {
+	maxPlayerCount := 0
+	if hasPlayerLimit, _ := strconv.ParseBool(room.roomRules["hasMaxPlayers"]); hasPlayerLimit {
+		maxPlayerCount, _ = strconv.Atoi(room.roomRules["maxPlayerCount"])
+	}
+	return RoomInfo{
+		RoomId:         room.roomId,
+		SceneIndex:     room.sceneIndex,
+		GameModeType:   room.roomRules["gameModeType"],
+		PlayerCount:    len(room.players),
+		MaxPlayerCount: maxPlayerCount,
+		HasStarted:     !room.isOpen,
+		HasPassword:    room.hasPassword(),
+	}
+}
+
+func (room *RoomBase) getOtherClientData() []ClientData {
+	allClientData := []ClientData{}
+	for _, client := range room.players {
+		planeTypes := strings.Split(strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(client.planeTypes, "\"", ""), "[", ""), "]", ""), ",")
+		allClientData = append(allClientData, ClientData{Id: client.playerId, Name: client.name, Team: client.currentTeam, PlaneTypes: planeTypes, PlayerHealth: client.currentHealth, IsReady: client.isReady})
+	}
+	return allClientData
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Lets a player who lost the connection take its place in the room back by sending the resume token in its hello
+type resumeSession struct {
+	playerId int
+	//Only set while the player is disconnected and waiting to come back
+	expiry *time.Timer
+}
+
+// Both guarded by lobbyMutex
+var resumeSessions = map[string]*resumeSession{}
+var resumeTokens = map[int]string{}
+
+func newResumeToken(playerId int) string { //Replaces the resume token of the player with a new one
+	token := getRandomToken()
+	lobbyMutex.Lock()
+	delete(resumeSessions, resumeTokens[playerId])
+	resumeTokens[playerId] = token
+	resumeSessions[token] = &resumeSession{playerId: playerId}
+	lobbyMutex.Unlock()
+	return token
+}
+
+func dropResumeSession(playerId int) {
+	lobbyMutex.Lock()
+	if session, ok := resumeSessions[resumeTokens[playerId]]; ok && session.expiry != nil {
+		session.expiry.Stop()
+	}
+	delete(resumeSessions, resumeTokens[playerId])
+	delete(resumeTokens, playerId)
+	lobbyMutex.Unlock()
+}
+
+func claimResumeSession(token string) (*resumeSession, bool) { //Makes sure a session is only ever resumed or expired once
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	session, ok := resumeSessions[token]
+	if !ok {
+		return nil, false
+	}
+	//If the timer already fired the session is being expired right now
+	if session.expiry != nil && !session.expiry.Stop() {
+		return nil, false
+	}
+	delete(resumeSessions, token)
+	delete(resumeTokens, session.playerId)
+	return session, true
+}
+
+func waitForResume(playerId int, roomId string) bool { //Gives the player resumeGracePeriod to come back before it is removed from the room, has to be called inside the room
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	token, ok := resumeTokens[playerId]
+	if !ok {
+		return false
+	}
+	session := resumeSessions[token]
+	session.expiry = time.AfterFunc(resumeGracePeriod, func() {
+		lobbyMutex.Lock()
+		if resumeSessions[token] != session {
+			lobbyMutex.Unlock()
+			return
+		}
+		delete(resumeSessions, token)
+		delete(resumeTokens, playerId)
+		lobbyMutex.Unlock()
+		hasExpired := false
+		withRoom(roomId, func(room *RoomBase) {
+			//Only removing the player if it really is still without a connection
+			if p, ok := room.players[playerId]; ok && p.websocket == nil {
+				hasExpired = true
+				room.disconnectPlayer(playerId)
+			}
+		})
+		//A kick or ban while the player was away already moved it back into the lobby
+		if !hasExpired {
+			lobbyMutex.Lock()
+			if p, ok := playersWithoutRoom[playerId]; ok && p.websocket == nil {
+				hasExpired = true
+			}
+			lobbyMutex.Unlock()
+		}
+		if hasExpired {
+			fmt.Println("Player", playerId, "didn't come back in time")
+			removePlayerFromLobby(playerId)
+		}
+	})
+	return true
+}
+
+func resumePlayer(conn *websocket.Conn, outbound chan []byte, hello *HelloRequest, capabilities map[string]bool, agreedCapabilities []string) (*Player, bool) {
+	session, ok := claimResumeSession(string(hello.ResumeToken))
+	if !ok {
+		return nil, false
+	}
+	playerId := session.playerId
+	roomId, ok := getPlayerRoom(playerId)
+	if !ok {
+		return nil, false
+	}
+	resumed := false
+	sender := Player{websocket: conn, outbound: outbound, playerId: strconv.Itoa(playerId), sessionToken: getRandomToken(), capabilities: capabilities}
+	withRoom(roomId, func(room *RoomBase) {
+		returningPlayer, ok := room.players[playerId]
+		if !ok {
+			return
+		}
+		oldConnection := returningPlayer.websocket
+		returningPlayer.websocket = conn
+		returningPlayer.outbound = outbound
+		returningPlayer.sessionToken = sender.sessionToken
+		returningPlayer.capabilities = capabilities
+		//The player most likely comes from a different address now
+		returningPlayer.udpConn = nil
+		returningPlayer.udpAddr = nil
+		returningPlayer.hasSequence = false
+		returningPlayer.snapshots = nil
+		room.players[playerId] = returningPlayer
+		resumed = true
+
+		hm := HelloMessage{
+			NewId:           playerId,
+			Token:           sender.sessionToken,
+			ResumeToken:     newResumeToken(playerId),
+			Resumed:         true,
+			ProtocolVersion: PROTOCOL_VERSION,
+			Capabilities:    agreedCapabilities,
+		}
+		sendTCP(&returningPlayer, encodeMessage(hm))
+		rsm := ResumeStateMessage{
+			RoomId:       room.roomId,
+			SceneIndex:   room.sceneIndex,
+			GameMode:     room.roomRules["gameModeType"],
+			GameStarted:  !room.isOpen,
+			OwnerId:      room.ownerId,
+			Team:         returningPlayer.currentTeam,
+			Health:       returningPlayer.currentHealth,
+			Kills:        returningPlayer.kills,
+			IsDead:       returningPlayer.isDead,
+			IsReady:      returningPlayer.isReady,
+			OtherClients: room.getOtherClientData(),
+		}
+		sendTCP(&returningPlayer, encodeMessage(rsm))
+		room.broadcastTCP(encodeMessage(ClientReconnectedMessage{Id: playerId}))
+		//The old connection might not have noticed yet that it is dead
+		if oldConnection != nil && oldConnection != conn {
+			oldConnection.Close()
+		}
+	})
+	if !resumed {
+		return nil, false
+	}
+	fmt.Println("Player", playerId, "resumed its session in room", roomId)
+	return &sender, true
+}
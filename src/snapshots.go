@@ -0,0 +1,203 @@
+package main
+
+import "strconv"
+
+// Players with the deltaSnapshots capability acknowledge the snapshots they got, after that they are only sent
+// the transforms that changed since the newest snapshot they acknowledged
+type snapshotState struct {
+	//The full transforms of every snapshot that was sent to the player and not yet acknowledged
+	history      map[uint32]map[int]Transform
+	ackedId      uint32
+	hasAck       bool
+	lastKeyframe uint32
+}
+
+// One tick worth of transforms for a player, encode splits it into as many datagrams as needed
+type transformSnapshot struct {
+	snapshotId     uint32
+	baseSnapshotId uint32
+	isKeyframe     bool
+	isDelta        bool
+	transforms     map[int]Transform
+	removed        []int
+}
+
+func (room *RoomBase) sendSnapshot(playerId int, p Player, snapshotId uint32, transforms map[int]Transform, held map[int]bool) { //Held planes were left out this tick but the player still knows about them
+	if p.udpConn == nil {
+		return
+	}
+	state := p.snapshots
+	if state == nil {
+		state = &snapshotState{history: map[uint32]map[int]Transform{}}
+		p.snapshots = state
+		room.players[playerId] = p
+	}
+	baseline, hasBaseline := state.history[state.ackedId]
+	if len(held) > 0 {
+		//Remembering the held planes the way the player last got them
+		current := map[int]Transform{}
+		for id, transform := range transforms {
+			current[id] = transform
+		}
+		for id := range held {
+			if transform, ok := baseline[id]; ok {
+				current[id] = transform
+			}
+		}
+		transforms = current
+	}
+	if len(transforms) == 0 && len(baseline) == 0 {
+		return
+	}
+	//A full snapshot is sent every now and then in case the history got out of hand
+	isKeyframe := !state.hasAck || !hasBaseline || snapshotId-state.lastKeyframe >= uint32(snapshotKeyframeInterval)
+	changed := map[int]Transform{}
+	removed := []int{}
+	if isKeyframe {
+		changed = transforms
+		state.lastKeyframe = snapshotId
+	} else {
+		for id, transform := range transforms {
+			if old, ok := baseline[id]; !ok || !old.looksLike(transform) {
+				changed[id] = transform
+			}
+		}
+		for id := range baseline {
+			if _, ok := transforms[id]; !ok {
+				removed = append(removed, id)
+			}
+		}
+		if len(changed) == 0 && len(removed) == 0 {
+			return
+		}
+	}
+	state.history[snapshotId] = transforms
+	//Forgetting the oldest snapshots if the player stopped acknowledging them
+	for id := range state.history {
+		if snapshotId-id >= uint32(snapshotHistorySize) {
+			delete(state.history, id)
+		}
+	}
+	snapshot := transformSnapshot{
+		snapshotId:     snapshotId,
+		baseSnapshotId: state.ackedId,
+		isKeyframe:     isKeyframe,
+		isDelta:        true,
+		transforms:     changed,
+		removed:        removed,
+	}
+	for _, datagram := range snapshot.encode(p.capabilities["binaryTransforms"]) {
+		sendUDP(&p, datagram)
+	}
+}
+
+func (room *RoomBase) ackSnapshot(playerId int, snapshotId uint32) {
+	p, ok := room.players[playerId]
+	if !ok || p.snapshots == nil {
+		return
+	}
+	state := p.snapshots
+	if _, ok := state.history[snapshotId]; !ok {
+		return
+	}
+	//Acks can arrive out of order, only newer ones move the baseline
+	if state.hasAck && int32(snapshotId-state.ackedId) <= 0 {
+		return
+	}
+	state.ackedId = snapshotId
+	state.hasAck = true
+	for id := range state.history {
+		if int32(id-snapshotId) < 0 {
+			delete(state.history, id)
+		}
+	}
+}
+
+func (s transformSnapshot) encode(isBinary bool) []string { //Splits the snapshot so no datagram is bigger than maxDatagramSize
+	ids := sortedIds(s.transforms)
+	if isBinary {
+		//There are no numbers to send for transforms the server couldn't read
+		readable := []int{}
+		for _, id := range ids {
+			if !s.transforms[id].onlyRaw {
+				readable = append(readable, id)
+			}
+		}
+		ids = readable
+	}
+	//How big every entry is decides where the snapshot is split
+	entrySize := func(id int) int {
+		if isBinary {
+			return len(appendBinaryTransform(nil, id, s.transforms[id]))
+		}
+		return len(strconv.Itoa(id)) + len(s.transforms[id].String()) + 6
+	}
+	//Leaving room for the fields around the entries
+	budget := maxDatagramSize - 128 - 8*len(s.removed)
+	parts := [][]int{{}}
+	size := 0
+	for _, id := range ids {
+		if size+entrySize(id) > budget && len(parts[len(parts)-1]) > 0 {
+			parts = append(parts, []int{})
+			size = 0
+		}
+		parts[len(parts)-1] = append(parts[len(parts)-1], id)
+		size += entrySize(id)
+	}
+	datagrams := []string{}
+	for i, part := range parts {
+		//Removed players only have to be told once
+		removed := []int{}
+		if i == 0 {
+			removed = s.removed
+		}
+		if isBinary {
+			datagrams = append(datagrams, s.encodeBinaryPart(part, i, len(parts), removed))
+			continue
+		}
+		transforms := map[int]string{}
+		for _, id := range part {
+			transforms[id] = s.transforms[id].String()
+		}
+		if !s.isDelta {
+			datagrams = append(datagrams, encodeMessage(UpdatePlayerTransformMessage{AllPlayerTransformDict: transforms}))
+			continue
+		}
+		dsm := DeltaSnapshotMessage{
+			SnapshotId:             s.snapshotId,
+			BaseSnapshotId:         s.baseSnapshotId,
+			IsKeyframe:             s.isKeyframe,
+			Part:                   i,
+			PartCount:              len(parts),
+			AllPlayerTransformDict: transforms,
+			RemovedPlayers:         removed,
+		}
+		datagrams = append(datagrams, encodeMessage(dsm))
+	}
+	return datagrams
+}
+
+func (s transformSnapshot) encodeBinaryPart(ids []int, part int, partCount int, removed []int) string {
+	flags := byte(0)
+	if s.isKeyframe {
+		flags |= SNAPSHOT_KEYFRAME
+	}
+	if s.isDelta {
+		flags |= SNAPSHOT_DELTA
+	}
+	buf := []byte{BINARY_TRANSFORM_SNAPSHOT}
+	buf = appendUint(buf, uint64(s.snapshotId))
+	buf = appendUint(buf, uint64(s.baseSnapshotId))
+	buf = append(buf, flags)
+	buf = appendUint(buf, uint64(part))
+	buf = appendUint(buf, uint64(partCount))
+	buf = appendUint(buf, uint64(len(ids)))
+	for _, id := range ids {
+		buf = appendBinaryTransform(buf, id, s.transforms[id])
+	}
+	buf = appendUint(buf, uint64(len(removed)))
+	for _, id := range removed {
+		buf = appendUint(buf, uint64(id))
+	}
+	return string(buf)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"testing"
+)
+
+// Keeps the datagrams instead of sending them
+type recordingConn struct {
+	net.PacketConn
+	datagrams []string
+}
+
+func (c *recordingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.datagrams = append(c.datagrams, string(b))
+	return len(b), nil
+}
+
+func newSnapshotRoom() (*RoomBase, *recordingConn) {
+	conn := &recordingConn{}
+	room := newRoom("1", map[string]string{}, []string{"red"})
+	room.players[1] = Player{playerId: "1", udpConn: conn, udpAddr: &net.UDPAddr{}, capabilities: map[string]bool{"deltaSnapshots": true}}
+	return room, conn
+}
+
+func sendTestSnapshot(t *testing.T, room *RoomBase, conn *recordingConn, snapshotId uint32, transforms map[int]Transform, held map[int]bool) (DeltaSnapshotMessage, bool) {
+	conn.datagrams = nil
+	room.sendSnapshot(1, room.players[1], snapshotId, transforms, held)
+	if len(conn.datagrams) == 0 {
+		return DeltaSnapshotMessage{}, false
+	}
+	if len(conn.datagrams) != 1 {
+		t.Fatalf("snapshot %d was split into %d datagrams", snapshotId, len(conn.datagrams))
+	}
+	var dsm DeltaSnapshotMessage
+	if err := json.Unmarshal([]byte(conn.datagrams[0]), &dsm); err != nil {
+		t.Fatal(err)
+	}
+	return dsm, true
+}
+
+func at(x float64) Transform {
+	return Transform{Position: [3]float64{x, 0, 0}}
+}
+
+func playerIds(dsm DeltaSnapshotMessage) []int {
+	ids := []int{}
+	for id := range dsm.AllPlayerTransformDict {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func sameIds(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSnapshotDeltas(t *testing.T) {
+	room, conn := newSnapshotRoom()
+	//Everything is sent in full until the player acknowledges a snapshot
+	for _, snapshotId := range []uint32{1, 2} {
+		dsm, _ := sendTestSnapshot(t, room, conn, snapshotId, map[int]Transform{2: at(0), 3: at(0)}, nil)
+		if !dsm.IsKeyframe || !sameIds(playerIds(dsm), []int{2, 3}) {
+			t.Fatalf("snapshot %d should be a full keyframe: %+v", snapshotId, dsm)
+		}
+	}
+	room.ackSnapshot(1, 1)
+	//Only the plane that moved is sent
+	dsm, _ := sendTestSnapshot(t, room, conn, 3, map[int]Transform{2: at(5), 3: at(0)}, nil)
+	if dsm.IsKeyframe || dsm.BaseSnapshotId != 1 || !sameIds(playerIds(dsm), []int{2}) || len(dsm.RemovedPlayers) != 0 {
+		t.Fatalf("expected a delta with player 2 against snapshot 1: %+v", dsm)
+	}
+	//Planes that are gone are listed as removed
+	dsm, _ = sendTestSnapshot(t, room, conn, 4, map[int]Transform{2: at(0)}, nil)
+	if dsm.IsKeyframe || len(playerIds(dsm)) != 0 || !sameIds(dsm.RemovedPlayers, []int{3}) {
+		t.Fatalf("expected player 3 to be removed: %+v", dsm)
+	}
+	//Nothing is sent if nothing changed
+	if dsm, sent := sendTestSnapshot(t, room, conn, 5, map[int]Transform{2: at(0), 3: at(0)}, nil); sent {
+		t.Fatalf("nothing changed but a snapshot was sent: %+v", dsm)
+	}
+}
+
+func TestSnapshotHeldPlanes(t *testing.T) {
+	room, conn := newSnapshotRoom()
+	sendTestSnapshot(t, room, conn, 1, map[int]Transform{2: at(0), 3: at(0)}, nil)
+	room.ackSnapshot(1, 1)
+	//Player 3 was left out this tick, the player still has to see it where it was
+	dsm, _ := sendTestSnapshot(t, room, conn, 2, map[int]Transform{2: at(5)}, map[int]bool{3: true})
+	if !sameIds(playerIds(dsm), []int{2}) || len(dsm.RemovedPlayers) != 0 {
+		t.Fatalf("a held plane was sent or removed: %+v", dsm)
+	}
+	room.ackSnapshot(1, 2)
+	//The held plane is still in the new baseline
+	dsm, _ = sendTestSnapshot(t, room, conn, 3, map[int]Transform{2: at(10)}, map[int]bool{3: true})
+	if !sameIds(playerIds(dsm), []int{2}) || len(dsm.RemovedPlayers) != 0 {
+		t.Fatalf("the held plane got lost from the baseline: %+v", dsm)
+	}
+	//Once it isn't held anymore it is removed
+	dsm, _ = sendTestSnapshot(t, room, conn, 4, map[int]Transform{2: at(10)}, nil)
+	if !sameIds(dsm.RemovedPlayers, []int{3}) {
+		t.Fatalf("expected player 3 to be removed: %+v", dsm)
+	}
+}
+
+func TestSnapshotAcks(t *testing.T) {
+	room, conn := newSnapshotRoom()
+	for snapshotId := uint32(1); snapshotId <= 3; snapshotId++ {
+		sendTestSnapshot(t, room, conn, snapshotId, map[int]Transform{2: at(float64(snapshotId))}, nil)
+	}
+	room.ackSnapshot(1, 3)
+	//An older ack arriving late doesn't move the baseline back
+	room.ackSnapshot(1, 2)
+	//Snapshots the player was never sent are ignored
+	room.ackSnapshot(1, 100)
+	state := room.players[1].snapshots
+	if !state.hasAck || state.ackedId != 3 {
+		t.Fatalf("expected snapshot 3 to be the baseline but it is %d", state.ackedId)
+	}
+	if _, ok := state.history[2]; ok {
+		t.Fatal("snapshots older than the baseline should be forgotten")
+	}
+	dsm, _ := sendTestSnapshot(t, room, conn, 4, map[int]Transform{2: at(4)}, nil)
+	if dsm.IsKeyframe || dsm.BaseSnapshotId != 3 {
+		t.Fatalf("expected a delta against snapshot 3: %+v", dsm)
+	}
+}
+
+func TestSnapshotKeyframeInterval(t *testing.T) {
+	room, conn := newSnapshotRoom()
+	sendTestSnapshot(t, room, conn, 1, map[int]Transform{2: at(0)}, nil)
+	room.ackSnapshot(1, 1)
+	for snapshotId := uint32(2); snapshotId <= uint32(snapshotKeyframeInterval)+1; snapshotId++ {
+		dsm, _ := sendTestSnapshot(t, room, conn, snapshotId, map[int]Transform{2: at(float64(snapshotId))}, nil)
+		isKeyframe := snapshotId == uint32(snapshotKeyframeInterval)+1
+		if dsm.IsKeyframe != isKeyframe {
+			t.Fatalf("snapshot %d: expected keyframe %v", snapshotId, isKeyframe)
+		}
+		room.ackSnapshot(1, snapshotId)
+	}
+}
+
+func TestIsFreshDatagram(t *testing.T) {
+	var p Player
+	if !p.isFreshDatagram(map[string]interface{}{}) {
+		t.Fatal("datagrams without a sequence are always fresh")
+	}
+	datagrams := []struct {
+		sequence float64
+		fresh    bool
+	}{
+		{4294967294, true},
+		{4294967295, true},
+		{4294967295, false}, //Duplicate
+		{0, true},           //Wrapped around
+		{4294967294, false}, //From before the wrap
+		{2, true},
+		{1, false},
+	}
+	for _, datagram := range datagrams {
+		if fresh := p.isFreshDatagram(map[string]interface{}{"sequence": datagram.sequence}); fresh != datagram.fresh {
+			t.Fatalf("sequence %v: expected fresh %v but got %v", datagram.sequence, datagram.fresh, fresh)
+		}
+	}
+	if p.droppedPackets != 3 || p.reorderedPackets != 2 {
+		t.Fatalf("expected 3 dropped and 2 reordered packets but got %d and %d", p.droppedPackets, p.reorderedPackets)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where a player is and where it is heading, on the wire it is still sent the way the client sent it
+type Transform struct {
+	Position [3]float64
+	Rotation [3]float64
+	Velocity [3]float64
+	//When the server received the update
+	Timestamp time.Time
+	Sequence  uint32
+	//The transform string of the client, only the position in it is changed if the move had to be clamped
+	raw string
+	//Set for lobby transforms the server couldn't read, only the raw string of them can be passed on
+	onlyRaw bool
+}
+
+func parseTransform(transform string) (Transform, bool) { //Reads a transform like "(1.0, 2.0, 3.0)|(0.0, 90.0, 0.0)", a third group is taken as the velocity
+	t := Transform{raw: transform}
+	groups := [][]float64{}
+	for _, group := range strings.Split(transform, "|") {
+		numbers := []float64{}
+		fields := strings.FieldsFunc(group, func(r rune) bool {
+			return strings.ContainsRune("(), ", r)
+		})
+		for _, field := range fields {
+			number, err := strconv.ParseFloat(field, 64)
+			if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+				return t, false
+			}
+			numbers = append(numbers, number)
+		}
+		groups = append(groups, numbers)
+	}
+	//Binary clients get the rotation as three numbers, so a quaternion can't be passed on to them
+	if len(groups) < 2 || len(groups[0]) != 3 || len(groups[1]) != 3 {
+		return t, false
+	}
+	copy(t.Position[:], groups[0])
+	copy(t.Rotation[:], groups[1])
+	if len(groups) >= 3 && len(groups[2]) == 3 {
+		copy(t.Velocity[:], groups[2])
+	}
+	return t, true
+}
+
+func readTransform(message map[string]interface{}, previous *Player, now time.Time) (Transform, bool) { //Builds the transform out of a transformUpdate datagram
+	t, ok := parseTransform(fmt.Sprintf("%v", message["newTransform"]))
+	if !ok {
+		return t, false
+	}
+	t.Timestamp = now
+	t.Sequence, _ = readSequence(message)
+	if velocity, ok := message["velocity"].([]interface{}); ok && len(velocity) == 3 {
+		for i, v := range velocity {
+			t.Velocity[i], _ = v.(float64)
+		}
+	} else if previous.hasTransform && t.Velocity == [3]float64{} {
+		//Working the velocity out from the last update if the client doesn't send it
+		if elapsed := now.Sub(previous.transform.Timestamp).Seconds(); elapsed > 0 {
+			for i := range t.Velocity {
+				t.Velocity[i] = (t.Position[i] - previous.transform.Position[i]) / elapsed
+			}
+		}
+	}
+	return t, true
+}
+
+func readSequence(message map[string]interface{}) (uint32, bool) { //Older clients don't number their datagrams
+	return readUint32(message, "sequence")
+}
+
+func readUint32(message map[string]interface{}, key string) (uint32, bool) {
+	value, ok := message[key]
+	if !ok {
+		return 0, false
+	}
+	sequence, err := strconv.ParseFloat(fmt.Sprintf("%v", value), 64)
+	if err != nil || sequence < 0 || sequence > math.MaxUint32 {
+		return 0, false
+	}
+	return uint32(sequence), true
+}
+
+func (p *Player) isFreshDatagram(message map[string]interface{}) bool { //Datagrams are handled in their own goroutines, so an older one can arrive after a newer one
+	sequence, ok := readSequence(message)
+	if !ok {
+		return true
+	}
+	if p.hasSequence {
+		//Comparing the difference lets the sequence wrap around
+		if difference := int32(sequence - p.lastSequence); difference <= 0 {
+			p.droppedPackets++
+			if difference < 0 {
+				p.reorderedPackets++
+			}
+			return false
+		}
+	}
+	p.lastSequence = sequence
+	p.hasSequence = true
+	return true
+}
+
+func (t Transform) String() string { //The format the clients expect in updatePlayerTransform
+	if len(t.raw) > 0 {
+		return t.raw
+	}
+	return fmt.Sprintf("(%.3f, %.3f, %.3f)|(%.3f, %.3f, %.3f)", t.Position[0], t.Position[1], t.Position[2], t.Rotation[0], t.Rotation[1], t.Rotation[2])
+}
+
+func (t *Transform) setPosition(position [3]float64) { //Keeps everything after the position the way the client sent it
+	t.Position = position
+	if len(t.raw) == 0 {
+		return
+	}
+	rest := ""
+	if i := strings.Index(t.raw, ")"); i >= 0 {
+		rest = t.raw[i+1:]
+	}
+	t.raw = fmt.Sprintf("(%.3f, %.3f, %.3f)", position[0], position[1], position[2]) + rest
+}
+
+func (t Transform) looksLike(other Transform) bool { //Tells if the clients would see any difference between the two
+	return t.String() == other.String()
+}
+
+func (t Transform) distanceTo(other Transform) float64 {
+	distance := 0.0
+	for i := range t.Position {
+		distance += (t.Position[i] - other.Position[i]) * (t.Position[i] - other.Position[i])
+	}
+	return math.Sqrt(distance)
+}
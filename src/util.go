@@ -2,6 +2,10 @@ package main
 
 import (
 	"bufio"
+	cryptoRand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
@@ -10,9 +14,7 @@ import (
 	"time"
 )
 
-func getRandomRoomId() string {
-	mutex.Lock()
-	defer mutex.Unlock()
+func getRandomRoomId() string { //The caller has to hold roomsMutex
 	/*
 		newRoomId := "AAAAAA"
 		if _, ok := rooms[newRoomId]; ok {
@@ -24,16 +26,28 @@ func getRandomRoomId() string {
 	//ENABLE AFTER ENTKÄFERUNG
 	rand.Seed(time.Now().UnixNano())
 	alphabet := strings.ToUpper("abcdefghijklmnopqrstuvwxyz")
-	roomId := ""
-	for i := 0; i < 6; i++ {
-		roomId = roomId + string(alphabet[rand.Intn(len(alphabet)-1)])
+	for {
+		roomId := ""
+		for i := 0; i < 6; i++ {
+			roomId = roomId + string(alphabet[rand.Intn(len(alphabet)-1)])
+		}
+		if _, ok := rooms[roomId]; !ok {
+			return roomId
+		}
 	}
-	if _, ok := rooms[roomId]; ok {
-		return getRandomRoomId()
-	} else {
-		return roomId
+}
+
+func getRandomToken() string {
+	tokenBytes := make([]byte, 16)
+	if _, err := cryptoRand.Read(tokenBytes); err != nil {
+		log.Fatal(err)
 	}
+	return hex.EncodeToString(tokenBytes)
+}
 
+func hashPassword(password string, salt string) string {
+	hash := sha256.Sum256([]byte(salt + password))
+	return hex.EncodeToString(hash[:])
 }
 
 func convertMap(ipt map[string]interface{}) map[string]string {
@@ -44,6 +58,21 @@ func convertMap(ipt map[string]interface{}) map[string]string {
 	return out
 }
 
+func getPlaneTypes(planeTypes json.RawMessage) string { //Keeps the plane types as the JSON the client sent them in
+	if len(planeTypes) == 0 {
+		return "null"
+	}
+	return string(planeTypes)
+}
+
+func toFloats(values []flexFloat) []float64 {
+	result := make([]float64, len(values))
+	for i, v := range values {
+		result[i] = float64(v)
+	}
+	return result
+}
+
 func readFile(file string) []string {
 	var result = []string{}
 	f, err := os.Open(file)
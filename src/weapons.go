@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// What a single bullet or rocket type does, the "default" entry of a table is used for types it doesn't list.
+// A MagazineSize of 0 means the weapon never has to be reloaded, a Range of 0 that it reaches everywhere.
+// Rockets with a Speed are flown by the server, TurnRate is in degrees per second
+type WeaponStats struct {
+	Damage       int     `json:"damage"`
+	CooldownMs   int     `json:"cooldownMs"`
+	MagazineSize int     `json:"magazineSize"`
+	ReloadMs     int     `json:"reloadMs"`
+	Range        float64 `json:"range"`
+	Speed        float64 `json:"speed"`
+	TurnRate     float64 `json:"turnRate"`
+	LifetimeMs   int     `json:"lifetimeMs"`
+	HitRadius    float64 `json:"hitRadius"`
+}
+
+// The ammo and timing of one weapon of a player, only touched inside the room
+type weaponState struct {
+	lastShot    time.Time
+	shotsLeft   int
+	reloadedAt  time.Time
+	isReloading bool
+	//When the shots that haven't hit anything yet were fired, oldest first
+	openShots []time.Time
+}
+
+type WeaponTables struct {
+	Bullets map[string]WeaponStats `json:"bullets"`
+	Rockets map[string]WeaponStats `json:"rockets"`
+}
+
+var weapons WeaponTables
+
+func readWeapons(file string) WeaponTables {
+	var result WeaponTables
+	content, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		log.Fatal(err)
+	}
+	return result
+}
+
+func getBulletStats(bulletType string) (WeaponStats, bool) {
+	return lookupWeapon(weapons.Bullets, bulletType)
+}
+
+func getRocketStats(rocketType string) (WeaponStats, bool) {
+	return lookupWeapon(weapons.Rockets, rocketType)
+}
+
+func lookupWeapon(table map[string]WeaponStats, weaponType string) (WeaponStats, bool) {
+	if stats, ok := table[weaponType]; ok {
+		return stats, true
+	}
+	stats, ok := table["default"]
+	return stats, ok
+}
+
+func (w *weaponState) tryShot(stats WeaponStats, now time.Time) bool { //Uses up one shot if the weapon is ready to fire
+	if w.isReloading {
+		if now.Before(w.reloadedAt) {
+			return false
+		}
+		w.isReloading = false
+		w.shotsLeft = stats.MagazineSize
+	}
+	cooldown := time.Duration(float64(stats.CooldownMs)*fireRateTolerance) * time.Millisecond
+	if !w.lastShot.IsZero() && now.Sub(w.lastShot) < cooldown {
+		return false
+	}
+	w.lastShot = now
+	w.dropOldShots(stats, now)
+	w.openShots = append(w.openShots, now)
+	if stats.MagazineSize > 0 {
+		w.shotsLeft--
+		if w.shotsLeft <= 0 {
+			w.isReloading = true
+			w.reloadedAt = now.Add(time.Duration(float64(stats.ReloadMs)*fireRateTolerance) * time.Millisecond)
+		}
+	}
+	return true
+}
+
+func (w *weaponState) useShot(stats WeaponStats, now time.Time) bool { //Every reported hit needs a shot of the weapon that hasn't hit anything yet
+	w.dropOldShots(stats, now)
+	if len(w.openShots) == 0 {
+		return false
+	}
+	w.openShots = w.openShots[1:]
+	return true
+}
+
+func (w *weaponState) dropOldShots(stats WeaponStats, now time.Time) {
+	lifetime := shotLifetime
+	if stats.LifetimeMs > 0 {
+		lifetime = time.Duration(stats.LifetimeMs) * time.Millisecond
+	}
+	for len(w.openShots) > 0 && now.Sub(w.openShots[0]) > lifetime {
+		w.openShots = w.openShots[1:]
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTryShot(t *testing.T) {
+	stats := WeaponStats{CooldownMs: 100, MagazineSize: 3, ReloadMs: 1000}
+	start := time.Now()
+	//Milliseconds after start and if the shot may be fired, the tolerance is 0.8
+	shots := []struct {
+		at      int
+		allowed bool
+	}{
+		{0, true},
+		{50, false},  //Inside the cooldown
+		{85, true},   //Early, but within the tolerance
+		{200, true},  //Last shot of the magazine
+		{400, false}, //Reloading
+		{1100, true}, //Early, but within the tolerance of the reload
+		{1250, true},
+		{1400, true},
+		{1500, false}, //Reloading again
+	}
+	w := &weaponState{shotsLeft: stats.MagazineSize}
+	for _, shot := range shots {
+		if allowed := w.tryShot(stats, start.Add(time.Duration(shot.at)*time.Millisecond)); allowed != shot.allowed {
+			t.Fatalf("shot at %dms: expected %v but got %v", shot.at, shot.allowed, allowed)
+		}
+	}
+}
+
+func TestTryShotWithoutMagazine(t *testing.T) {
+	stats := WeaponStats{CooldownMs: 100}
+	start := time.Now()
+	w := &weaponState{}
+	for i := 0; i < 50; i++ {
+		if !w.tryShot(stats, start.Add(time.Duration(i*100)*time.Millisecond)) {
+			t.Fatalf("shot %d was rejected although the weapon never has to be reloaded", i)
+		}
+	}
+}
+
+func TestUseShot(t *testing.T) {
+	stats := WeaponStats{CooldownMs: 100, LifetimeMs: 1000}
+	start := time.Now()
+	w := &weaponState{}
+	w.tryShot(stats, start)
+	w.tryShot(stats, start.Add(500*time.Millisecond))
+	if !w.useShot(stats, start.Add(600*time.Millisecond)) || !w.useShot(stats, start.Add(600*time.Millisecond)) {
+		t.Fatal("both shots should be able to hit")
+	}
+	if w.useShot(stats, start.Add(600*time.Millisecond)) {
+		t.Fatal("a shot hit twice")
+	}
+	w.tryShot(stats, start.Add(2*time.Second))
+	if w.useShot(stats, start.Add(3100*time.Millisecond)) {
+		t.Fatal("a shot hit after its lifetime")
+	}
+}